@@ -25,7 +25,6 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -36,7 +35,9 @@ import (
 
 	"github.com/BasedDevelopment/auto/internal/config"
 	"github.com/BasedDevelopment/auto/internal/controllers"
+	"github.com/BasedDevelopment/auto/internal/libvirt"
 	"github.com/BasedDevelopment/auto/internal/server"
+	"github.com/BasedDevelopment/auto/pkg/models"
 	"github.com/BasedDevelopment/eve/pkg/fwdlog"
 	"github.com/rs/zerolog/log"
 )
@@ -59,7 +60,6 @@ var (
 )
 
 var binaries = []string{
-	"virt-install",
 	"qemu-img",
 	"genisoimage",
 }
@@ -128,19 +128,51 @@ func main() {
 		ErrorLog:  fwdlog.Logger(),
 	}
 
+	// Console listener, same certificate but no client authentication
+	var consoleSrv *http.Server
+	if config.Config.Console.Port != 0 {
+		consoleSrv = &http.Server{
+			Addr:    config.Config.Console.Host + ":" + strconv.Itoa(config.Config.Console.Port),
+			Handler: server.ConsoleService(config.Config.Console.AllowedOrigins),
+			TLSConfig: &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			},
+			ErrorLog: fwdlog.Logger(),
+		}
+	}
+
 	srvCtx, srvStopCtx := context.WithCancel(context.Background())
 
-	// Initialize the hypervisor
+	// Initialize the hypervisor, the libvirt connection is retried in the
+	// background if it fails here
+	hvCtx, hvStopCtx := context.WithCancel(context.Background())
 	hv := controllers.Hypervisor
-	hv.IP = net.ParseIP(config.Config.Libvirt.Host)
-	hv.Port = config.Config.Libvirt.Port
-	if err := hv.Init(); err != nil {
+	hv.URI = config.Config.Libvirt.URI
+	hv.Peers = make(map[string]*models.HVPeer)
+	for name, peer := range config.Config.Peers {
+		hv.Peers[name] = &models.HVPeer{Name: name, URI: peer.URI, SharedStorage: peer.SharedStorage}
+	}
+	if uri, _ := libvirt.ParseURI(hv.URI); uri.Transport == libvirt.TransportTLS {
+		crt, err := tls.LoadX509KeyPair(crtPath, keyPath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load libvirt client certificate")
+		}
+		hv.TLSConfig = &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			RootCAs:      caPool,
+			Certificates: []tls.Certificate{crt},
+		}
+	}
+	if err := hv.CheckFirewall(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize firewall")
+	}
+	if err := hv.Init(hvCtx); err != nil {
 		log.Error().Err(err).Msg("Failed to initialize hypervisor")
 	}
 
-	//if err := controllers.CheckStorage(); err != nil {
-	//	log.Error().Err(err).Msg("Failed to initialize storage")
-	//}
+	if err := hv.CheckStorage(); err != nil {
+		log.Error().Err(err).Msg("Failed to initialize storage")
+	}
 
 	// Watch for OS signals
 	sig := make(chan os.Signal, 1)
@@ -170,13 +202,40 @@ func main() {
 			log.Info().Msg("Webserver shutdown success")
 		}
 
-		// Libvirt connections
+		if consoleSrv != nil {
+			if err := consoleSrv.Shutdown(shutdownCtx); err != nil {
+				log.Error().
+					Err(err).
+					Msg("Failed to shutdown console listener")
+			}
+		}
+
+		// Libvirt connections, stop reconnecting first
+		hvStopCtx()
+		if controllers.Hypervisor.Libvirt != nil {
+			controllers.Hypervisor.Libvirt.Close()
+		}
 		log.Info().Msg("Libvirt connections shutdown success")
-		controllers.Hypervisor.Libvirt.Close()
 
 		srvStopCtx()
 	}()
 
+	if consoleSrv != nil {
+		log.Info().
+			Str("host", config.Config.Console.Host).
+			Int("port", config.Config.Console.Port).
+			Msg("Console listener listening")
+
+		go func() {
+			err := consoleSrv.ListenAndServeTLS(crtPath, keyPath)
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatal().
+					Err(err).
+					Msg("Failed to start console listener")
+			}
+		}()
+	}
+
 	// Start the server
 	err = srv.ListenAndServeTLS(crtPath, keyPath)
 
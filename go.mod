@@ -9,6 +9,7 @@ require (
 	github.com/go-chi/httprate v0.9.0
 	github.com/go-ozzo/ozzo-validation/v4 v4.3.0
 	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/knadh/koanf v1.5.0
 	github.com/rs/zerolog v1.32.0
 )
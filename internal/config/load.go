@@ -39,11 +39,30 @@ var (
 			Port int    `koanf:"port"`
 		} `koanf:"api"`
 
+		// Listener for browsers redeeming console tokens, no client
+		// certificate required. Disabled without a port.
+		Console struct {
+			Host string `koanf:"host"`
+			Port int    `koanf:"port"`
+			// Pages allowed to open consoles, as scheme://host[:port]
+			AllowedOrigins []string `koanf:"allowed_origins"`
+		} `koanf:"console"`
+
 		Libvirt struct {
+			URI string `koanf:"uri"`
+			// Deprecated, use URI
 			Host string `koanf:"host"`
 			Port int    `koanf:"port"`
 		} `koanf:"libvirt"`
 
+		// Other auto hosts domains can be migrated to, by name
+		Peers map[string]struct {
+			URI string `koanf:"uri"`
+			// Whether the peer sees the same disk paths, required to
+			// migrate shut off domains
+			SharedStorage bool `koanf:"shared_storage"`
+		} `koanf:"peers"`
+
 		Eve struct {
 			Serial string `koanf:"serial"`
 		} `koanf:"eve"`
@@ -56,6 +75,10 @@ var (
 			Disk       bool   `koanf:"disk"`
 			CloudImage bool   `koanf:"cloud_image"`
 			Remarks    string `koanf:"remarks"`
+			// Disks of lvm storages go to thin volumes, zfs ones to zvols
+			VolumeGroup string `koanf:"volume_group"`
+			ThinPool    string `koanf:"thin_pool"`
+			Dataset     string `koanf:"dataset"`
 		} `koanf:"storage"`
 
 		CloudInit struct {
@@ -64,11 +87,17 @@ var (
 			Path    string `koanf:"path"`
 		} `koanf:"cloud_init"`
 
+		// Per domain nftables rulesets, kept in path
+		Firewall struct {
+			Enabled bool   `koanf:"enabled"`
+			Path    string `koanf:"path"`
+		} `koanf:"firewall"`
+
 		Network map[string]struct {
 			Enabled bool   `koanf:"enabled"`
 			Type    string `koanf:"type"`
 			Remarks string `koanf:"remarks"`
-		}
+		} `koanf:"network"`
 	}
 )
 
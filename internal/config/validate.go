@@ -20,7 +20,9 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 
+	"github.com/BasedDevelopment/auto/internal/libvirt"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
 )
@@ -43,12 +45,70 @@ func validate() error {
 		return fmt.Errorf("Configuration: API port is not a valid port number: %d", Config.API.Port)
 	}
 
-	if err := validation.Validate(Config.Libvirt.Host, validation.Required, is.Host); err != nil {
-		return fmt.Errorf("Configuration: Libvirt host is not a hostname: %s", err)
+	if Config.Console.Port != 0 {
+		if err := validation.Validate(Config.Console.Host, validation.Required, is.IP); err != nil {
+			return fmt.Errorf("Configuration: console host is not an IP address: %s", err)
+		}
+
+		if (Config.Console.Port <= 1) || (Config.Console.Port >= 65535) {
+			return fmt.Errorf("Configuration: console port is not a valid port number: %d", Config.Console.Port)
+		}
+
+		if Config.Console.Port == Config.API.Port && Config.Console.Host == Config.API.Host {
+			return fmt.Errorf("Configuration: console listener can't share the API address")
+		}
+
+		for _, origin := range Config.Console.AllowedOrigins {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") || (u.Path != "" && u.Path != "/") {
+				return fmt.Errorf("Configuration: console allowed origin is not scheme://host[:port]: %s", origin)
+			}
+		}
+	}
+
+	// host and port predate the URI and always meant plain TCP
+	if Config.Libvirt.URI == "" {
+		if err := validation.Validate(Config.Libvirt.Host, validation.Required, is.Host); err != nil {
+			return fmt.Errorf("Configuration: Libvirt host is not a hostname: %s", err)
+		}
+
+		if (Config.Libvirt.Port <= 1) || (Config.Libvirt.Port >= 65535) {
+			return fmt.Errorf("Configuration: Libvirt port is not a valid port number: %d", Config.Libvirt.Port)
+		}
+
+		Config.Libvirt.URI = libvirt.TCPURI(Config.Libvirt.Host, Config.Libvirt.Port)
+	}
+
+	uri, err := libvirt.ParseURI(Config.Libvirt.URI)
+	if err != nil {
+		return fmt.Errorf("Configuration: Libvirt URI is not valid: %s", err)
+	}
+
+	if uri.Transport != libvirt.TransportUnix {
+		if err := validation.Validate(uri.Host, validation.Required, is.Host); err != nil {
+			return fmt.Errorf("Configuration: Libvirt URI host is not a hostname: %s", err)
+		}
+	}
+
+	// Peers are dialed by the local libvirtd, so they have to be remote
+	for name, peer := range Config.Peers {
+		uri, err := libvirt.ParseURI(peer.URI)
+		if err != nil {
+			return fmt.Errorf("Configuration: peer %s URI is not valid: %s", name, err)
+		}
+		if uri.Transport == libvirt.TransportUnix {
+			return fmt.Errorf("Configuration: peer %s URI can't be a unix socket", name)
+		}
+	}
+
+	for name, network := range Config.Network {
+		if err := validation.Validate(network.Type, validation.In("bridge", "ovs")); err != nil {
+			return fmt.Errorf("Configuration: network %s type: %s", name, err)
+		}
 	}
 
-	if (Config.Libvirt.Port <= 1) || (Config.Libvirt.Port >= 65535) {
-		return fmt.Errorf("Configuration: API port is not a valid port number: %d", Config.Libvirt.Port)
+	if Config.Firewall.Enabled && Config.Firewall.Path == "" {
+		return fmt.Errorf("Configuration: firewall path is required")
 	}
 
 	if err := validation.Validate(Config.Eve.Serial, validation.Required, is.Digit); err != nil {
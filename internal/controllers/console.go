@@ -0,0 +1,172 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/pkg/models"
+)
+
+// How long a console token can be redeemed for
+const consoleTokenTTL = 30 * time.Second
+
+var (
+	ErrDomainNotRunning = errors.New("domain is not running")
+	ErrInvalidToken     = errors.New("invalid or expired console token")
+)
+
+var consoleTokens = struct {
+	sync.Mutex
+	m map[string]models.ConsoleToken
+}{m: make(map[string]models.ConsoleToken)}
+
+// Issues a token that opens the console of the given type once, without a
+// client certificate
+func (hv *HV) IssueConsoleToken(vm *models.VM, kind string) (models.ConsoleToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return models.ConsoleToken{}, err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	t := models.ConsoleToken{
+		Token:   token,
+		Type:    kind,
+		Domain:  vm.ID,
+		Path:    "/console/" + token,
+		Expires: time.Now().Add(consoleTokenTTL),
+	}
+
+	consoleTokens.Lock()
+	defer consoleTokens.Unlock()
+	for k, old := range consoleTokens.m {
+		if time.Now().After(old.Expires) {
+			delete(consoleTokens.m, k)
+		}
+	}
+	consoleTokens.m[token] = t
+	return t, nil
+}
+
+// Consumes a token, it can't be redeemed again whether it's valid or not
+func (hv *HV) RedeemConsoleToken(token string) (*models.VM, string, error) {
+	consoleTokens.Lock()
+	t, ok := consoleTokens.m[token]
+	delete(consoleTokens.m, token)
+	consoleTokens.Unlock()
+
+	if !ok || time.Now().After(t.Expires) {
+		return nil, "", ErrInvalidToken
+	}
+
+	// The domain may be gone by now
+	vm, ok := hv.LookupVM(t.Domain)
+	if !ok {
+		return nil, "", ErrInvalidToken
+	}
+	return vm, t.Type, nil
+}
+
+// An attached serial console, output is streamed by Stream and In is nil
+// when input can't be sent
+type SerialSession struct {
+	In     io.WriteCloser
+	stream func(out io.Writer) error
+	conn   *libvirt.Libvirt
+	once   sync.Once
+}
+
+// Copies the console output to out until the console closes, out fails or
+// the session is closed
+func (s *SerialSession) Stream(out io.Writer) error {
+	return s.stream(out)
+}
+
+// Where input goes, nil when the console is read-only
+func (s *SerialSession) Input() io.Writer {
+	if s.In == nil {
+		return nil
+	}
+	return s.In
+}
+
+// Ends the session, a running Stream returns once the connection carrying
+// it is gone
+func (s *SerialSession) Close() (err error) {
+	s.once.Do(func() {
+		if s.In != nil {
+			err = s.In.Close()
+		}
+		if s.conn != nil {
+			if cerr := s.conn.Close(); err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+func (hv *HV) OpenSerialConsole(vm *models.VM) (*SerialSession, error) {
+	if err := hv.ensureConn(); err != nil {
+		return nil, err
+	}
+
+	active, err := hv.Libvirt.IsVMActive(vm.Domain)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrDomainNotRunning
+	}
+
+	c, err := hv.Libvirt.GetVMSerial(vm.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	// go-libvirt can't abort a console stream, it only ends along with the
+	// connection carrying it so every session gets its own
+	conn, err := libvirt.Init(hv.URI, hv.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Connect(); err != nil {
+		return nil, err
+	}
+	s := &SerialSession{conn: conn}
+
+	if hv.Libvirt.SerialWritable(c) {
+		if s.In, err = hv.Libvirt.OpenVMSerialInput(c); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	s.stream = func(out io.Writer) error {
+		return conn.StreamVMSerial(vm.Domain, out)
+	}
+	return s, nil
+}
@@ -0,0 +1,131 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/google/uuid"
+)
+
+func TestConsoleToken(t *testing.T) {
+	hv := testHV(t)
+	vm := &models.VM{ID: uuid.New()}
+	hv.VMs[vm.ID] = vm
+
+	issue := func(t *testing.T) models.ConsoleToken {
+		t.Helper()
+		token, err := hv.IssueConsoleToken(vm, "serial")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	for _, tc := range []struct {
+		name string
+		// Changes the token between issuing and redeeming it
+		prepare func(token models.ConsoleToken) string
+		redeems []error
+	}{
+		{
+			name:    "single use",
+			prepare: func(token models.ConsoleToken) string { return token.Token },
+			redeems: []error{nil, ErrInvalidToken},
+		},
+		{
+			name:    "unknown",
+			prepare: func(models.ConsoleToken) string { return "nope" },
+			redeems: []error{ErrInvalidToken},
+		},
+		{
+			name: "expired",
+			prepare: func(token models.ConsoleToken) string {
+				consoleTokens.Lock()
+				token.Expires = time.Now().Add(-time.Second)
+				consoleTokens.m[token.Token] = token
+				consoleTokens.Unlock()
+				return token.Token
+			},
+			// Expired tokens are consumed all the same
+			redeems: []error{ErrInvalidToken, ErrInvalidToken},
+		},
+		{
+			name: "domain gone",
+			prepare: func(token models.ConsoleToken) string {
+				consoleTokens.Lock()
+				token.Domain = uuid.New()
+				consoleTokens.m[token.Token] = token
+				consoleTokens.Unlock()
+				return token.Token
+			},
+			redeems: []error{ErrInvalidToken},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			token := tc.prepare(issue(t))
+			for i, want := range tc.redeems {
+				got, kind, err := hv.RedeemConsoleToken(token)
+				if !errors.Is(err, want) {
+					t.Fatalf("redeem %d: expected %v, got %v", i, want, err)
+				}
+				if err == nil && (got != vm || kind != "serial") {
+					t.Errorf("redeem %d: got %v, %s", i, got, kind)
+				}
+			}
+		})
+	}
+}
+
+func TestConsoleTokenTTL(t *testing.T) {
+	hv := testHV(t)
+	vm := &models.VM{ID: uuid.New()}
+
+	before := time.Now()
+	token, err := hv.IssueConsoleToken(vm, "vnc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Expires.Before(before.Add(consoleTokenTTL)) || token.Expires.After(time.Now().Add(consoleTokenTTL)) {
+		t.Errorf("token expires at %v, expected %v from now", token.Expires, consoleTokenTTL)
+	}
+	if token.Path != "/console/"+token.Token {
+		t.Errorf("unexpected path %s", token.Path)
+	}
+
+	// Expired tokens are dropped when the next one is issued
+	consoleTokens.Lock()
+	expired := token
+	expired.Expires = time.Now().Add(-time.Second)
+	consoleTokens.m[expired.Token] = expired
+	consoleTokens.Unlock()
+
+	if _, err := hv.IssueConsoleToken(vm, "vnc"); err != nil {
+		t.Fatal(err)
+	}
+	consoleTokens.Lock()
+	_, ok := consoleTokens.m[expired.Token]
+	consoleTokens.Unlock()
+	if ok {
+		t.Error("expired token kept")
+	}
+}
@@ -5,157 +5,162 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/storage"
 	"github.com/BasedDevelopment/auto/internal/util"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 )
 
-func (hv *HV) CreateDomain(domID uuid.UUID, req *util.DomainCreateRequest) (err error) {
+var ErrDomainExists = errors.New("domain already exists")
+
+func (hv *HV) CreateDomain(domID uuid.UUID, req *util.DomainCreateRequest, progress JobProgress) (err error) {
+	if _, exists := hv.LookupVM(domID); exists {
+		return ErrDomainExists
+	}
+
 	// Validation of disk and image path is here due to import cycle
 	if err := validation.ValidateStruct(req,
-		validation.Field(&req.Image, validation.In(Images)),
-		validation.Field(&req.CloudImage, validation.In(CloudImages)),
+		validation.Field(&req.Image, validation.By(inDirs(Images))),
+		validation.Field(&req.CloudImage, validation.By(inDirs(CloudImages))),
 	); err != nil {
 		return err
 	}
 
+	var nics []libvirt.DomNic
+	for _, iface := range req.Iface {
+		nic, err := domNic(iface)
+		if err != nil {
+			return err
+		}
+		nics = append(nics, nic)
+	}
+
 	if req.Cloud {
 		if len(CloudInitPath) == 0 {
 			return errors.New("no cloud-init path in auto config")
 		}
 	}
 
-	args := []string{
-		"--uuid", domID.String(),
-		"--name", req.Hostname,
-		"--memory", strconv.Itoa(req.Memory),
-		"--vcpus", strconv.Itoa(req.CPU),
-		"--os-variant", req.OSVariant,
-		//TODO: un hardcode this
-		"--boot", "cdrom,hd,menu=on",
-		"--graphics", "vnc,listen=0.0.0.0,websocket=-1",
-		"--noautoconsole",
+	if err := hv.ensureConn(); err != nil {
+		return err
 	}
 
-	/*
-		for _, disk := range req.Disk {
-			// check if disk.Path is empty or in []Disks
-			if disk.Path == "" {
-				return errors.New("disk path is empty")
-			}
-			if !util.Contains(Disks, disk.Path) {
-				return errors.New("disk path is not in auto config")
-			}
-			// Currently, disk is a map, but it is only being added as a array in storage.go, which throws an error because the type mismatch, i need to figure out what i wanted to put on there when i was changing the code.
-
-			//oh i think the map should be name -> path like proxmox
-			//if _, ok := Disks[disk.Path)
-			dir := disk.Path + "/" + domID.String()
+	var disks []libvirt.DomDisk
+	var created []string
 
-			if _, err := os.Stat(dir); os.IsNotExist(err) {
-				if err := os.Mkdir(dir, 0755); err != nil {
-					return err
-				}
-			}
+	// Each disk, the cloud-init ISO, defining and starting
+	steps := len(req.Disk) + 2
+	if req.Cloud {
+		steps++
+	}
+	step := 0
+	done := func() {
+		step++
+		progress(step * 100 / steps)
+	}
 
-			// If cloudinit, disk zero is the image disk
-			diskPath := dir + "/" + strconv.Itoa(disk.ID) + ".qcow2"
-			if disk.ID == 0 && req.Cloud {
-				if err := hv.CreateCloudDisk(diskPath, disk.Size, req.CloudImage); err != nil {
-					return err
-				}
-			} else {
-				if err := hv.CreateDisk(diskPath, disk.Size); err != nil {
-					return err
-				}
-			}
-			args = append(args, "--disk", "path="+diskPath+",format=qcow2")
+	// Remove whatever we created if we don't make it to a defined domain
+	defer func() {
+		if err == nil {
+			return
 		}
-
-		if req.Cloud {
-			cloudInitIsoPath := CloudInitPath + "/" + domID.String() + "-cidata.iso"
-			if err := hv.CreateCloudInitIso(cloudInitIsoPath, req.UserData, req.MetaData); err != nil {
-				return err
+		for _, path := range created {
+			if rmErr := hv.DeleteDiskFile(path); rmErr != nil {
+				log.Warn().
+					Err(rmErr).
+					Str("path", path).
+					Msg("failed to clean up after failed domain creation")
 			}
-			args = append(args, "--disk", "path="+cloudInitIsoPath+",device=cdrom")
 		}
+	}()
 
-		if req.Image != "" {
-			args = append(args, "--disk", "path="+req.Image+",device=cdrom")
+	for _, disk := range req.Disk {
+		// disk.Path names the storage, by name or by its disks directory
+		if disk.Path == "" {
+			return errors.New("disk path is empty")
+		}
+		backend, ok := diskBackend(disk.Path)
+		if !ok {
+			return errors.New("disk path is not in auto config")
 		}
-	*/
-
-	log.Debug().
-		Str("command", "virt-install").
-		Strs("args", args).
-		Msg("create domain")
 
-	/*
-		out, err := exec.Command("virt-install", args...).CombinedOutput()
+		// If cloudinit, disk zero is the image disk
+		name := domID.String() + "/" + strconv.Itoa(disk.ID)
+		var vol storage.Volume
+		if disk.ID == 0 && req.Cloud {
+			vol, err = backend.Clone(req.CloudImage, name, disk.Size)
+		} else {
+			vol, err = backend.Create(name, disk.Size)
+		}
 		if err != nil {
-			log.Error().
-				Err(err).
-				Str("command", "virt-install").
-				Strs("args", args).
-				Str("output", string(out)).
-				Msg("create domain")
 			return err
 		}
-	*/
-	out := "temp"
-	log.Debug().
-		Str("output", string(out)).
-		Msg("create domain")
+		created = append(created, vol.Path)
+		disks = append(disks, libvirt.DomDisk{Path: vol.Path, Format: vol.Format, Block: vol.Block})
+		done()
+	}
 
-	return hv.InitVMs()
-}
+	if req.Cloud {
+		cloudInitIsoPath := CloudInitPath + "/" + domID.String() + "-cidata.iso"
+		if err := hv.CreateCloudInitIso(cloudInitIsoPath, req.UserData, req.MetaData); err != nil {
+			return err
+		}
+		created = append(created, cloudInitIsoPath)
+		disks = append(disks, libvirt.DomDisk{Path: cloudInitIsoPath, Format: "raw", CDROM: true})
+		done()
+	}
+
+	if req.Image != "" {
+		disks = append(disks, libvirt.DomDisk{Path: req.Image, Format: "raw", CDROM: true})
+	}
+
+	def := libvirt.NewDomDef(domID, req, disks, nics)
 
-func (hv *HV) CreateDisk(path string, size int) error {
-	args := []string{"create", "-f", "qcow2", path, strconv.Itoa(size) + "G"}
 	log.Debug().
-		Str("command", "qemu-img").
-		Strs("args", args).
-		Msg("create disk")
-	out, err := exec.Command("qemu-img", args...).CombinedOutput()
+		Str("domain", domID.String()).
+		Str("hostname", req.Hostname).
+		Int("disks", len(disks)).
+		Msg("define domain")
+
+	dom, err := hv.Libvirt.DefineVM(def)
 	if err != nil {
-		log.Error().
-			Err(err).
-			Str("command", "qemu-img").
-			Strs("args", args).
-			Str("output", string(out)).
-			Msg("create disk")
 		return err
 	}
-	log.Debug().
-		Str("output", string(out)).
-		Msg("create disk")
 
-	return nil
-}
+	// The domain is defined at this point, failing to boot it should not
+	// remove the disks from under it
+	created = nil
+	done()
 
-func (hv *HV) CreateCloudDisk(path string, size int, image string) error {
-	args := []string{"create", "-b", image, "-f", "qcow2", "-F", "qcow2", path, strconv.Itoa(size) + "G"}
-	log.Debug().
-		Str("command", "qemu-img").
-		Strs("args", args).
-		Msg("create cloud disk")
-	out, err := exec.Command("qemu-img", args...).CombinedOutput()
-	if err != nil {
+	if err := hv.Libvirt.VMStart(dom); err != nil {
 		log.Error().
 			Err(err).
-			Str("command", "qemu-img").
-			Strs("args", args).
-			Str("output", string(out)).
-			Msg("create cloud disk")
+			Str("domain", domID.String()).
+			Msg("failed to start domain after definition")
 		return err
 	}
-	log.Debug().
-		Str("output", string(out)).
-		Msg("create cloud disk")
-	return nil
+	done()
+
+	return hv.InitVMs()
+}
+
+// Ensures a path given in a request lives in one of the configured directories
+func inDirs(dirs []string) validation.RuleFunc {
+	return func(value interface{}) error {
+		path, _ := value.(string)
+		if path == "" {
+			return nil
+		}
+		if !util.Contains(dirs, filepath.Dir(path)) {
+			return errors.New("path is not in auto config")
+		}
+		return nil
+	}
 }
 
 func (hv *HV) CreateCloudInitIso(path string, userData string, metaData string) error {
@@ -22,10 +22,21 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/BasedDevelopment/auto/internal/storage"
 	"github.com/BasedDevelopment/auto/pkg/models"
 )
 
+// What to remove from disk along with the domain
+const (
+	DeleteStorageKeep  = "keep"
+	DeleteStorageDisks = "disks"
+	DeleteStorageAll   = "all"
+)
+
+var ErrDiskNotManaged = errors.New("disk is outside of the storages, left in place")
+
 func (hv *HV) DestroyVM(vm *models.VM) error {
 	if err := hv.ensureConn(); err != nil {
 		return err
@@ -43,10 +54,152 @@ func (hv *HV) UndefineVM(vm *models.VM) error {
 		return err
 	}
 
-	return hv.InitVMs()
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+	delete(hv.VMs, vm.ID)
+
+	return nil
 }
 
+// Tears down a domain: destroy it if it is running, undefine it, then remove
+// the files requested by remove. The files are only touched once the domain
+// is gone from libvirt so a failure never leaves a defined domain without disks.
+func (hv *HV) DeleteDomain(vm *models.VM, remove string) (res models.VMDeleteResult, err error) {
+	res.ID = vm.ID
+
+	if err := hv.ensureConn(); err != nil {
+		return res, err
+	}
+
+	// Collect the files before undefining, the XML is gone afterwards
+	specs, err := hv.Libvirt.GetVMSpecs(vm.Domain)
+	if err != nil {
+		return res, err
+	}
+
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+
+	active, err := hv.Libvirt.IsVMActive(vm.Domain)
+	if err != nil {
+		return res, err
+	}
+	if active {
+		err = hv.DestroyVM(vm)
+		res.Steps = append(res.Steps, deleteStep("destroy", "", err))
+		if err != nil {
+			return res, err
+		}
+	}
+
+	err = hv.UndefineVM(vm)
+	res.Steps = append(res.Steps, deleteStep("undefine", "", err))
+	if err != nil {
+		return res, err
+	}
+
+	if fw != nil {
+		res.Steps = append(res.Steps, deleteStep("delete_firewall", "", hv.deleteFirewall(vm.ID)))
+	}
+
+	if remove == DeleteStorageKeep || remove == "" {
+		return res, nil
+	}
+
+	for _, disk := range specs.Devices.Disk {
+		path := disk.Source.File
+		if disk.Type == "block" {
+			path = disk.Source.Dev
+		}
+		if path == "" {
+			continue
+		}
+		switch {
+		case disk.Device == "disk" && disk.Type == "block":
+			res.Steps = append(res.Steps, hv.deleteDisk(path))
+		case disk.Device == "disk":
+			// External snapshots left overlays on top of the disk
+			for _, p := range diskChain(storage.ExecRunner{}, path) {
+				res.Steps = append(res.Steps, hv.deleteDisk(p))
+			}
+		case disk.Device == "cdrom" && remove == DeleteStorageAll &&
+			CloudInitPath != "" && filepath.Dir(path) == filepath.Clean(CloudInitPath):
+			// Only the generated cloud-init ISO, never the install images
+			res.Steps = append(res.Steps, deleteStep("delete_cloud_init", path, os.Remove(path)))
+		}
+	}
+
+	return res, nil
+}
+
+func deleteStep(step string, path string, err error) models.VMDeleteStepResult {
+	res := models.VMDeleteStepResult{
+		Step: step,
+		Path: path,
+		OK:   err == nil,
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// Deletes a disk of a domain that is gone, disks that weren't made in one of
+// the storages are only reported as skipped
+func (hv *HV) deleteDisk(path string) models.VMDeleteStepResult {
+	if !managedDisk(path) {
+		res := deleteStep("delete_disk", path, ErrDiskNotManaged)
+		res.Skipped = true
+		return res
+	}
+	return deleteStep("delete_disk", path, hv.DeleteDiskFile(path))
+}
+
+// Files making up a file disk: the disk itself and, for external snapshots,
+// the images below it in the same directory. Shared bases such as cloud images
+// live elsewhere and are left out.
+func diskChain(runner storage.Runner, path string) []string {
+	paths := []string{path}
+	chain, err := storage.Info(runner, path)
+	if err != nil {
+		return paths
+	}
+
+	dir := filepath.Dir(filepath.Clean(path))
+	for _, img := range chain {
+		next := img.FullBackingFilename
+		if next == "" {
+			next = img.BackingFilename
+		}
+		if next == "" || filepath.Dir(filepath.Clean(next)) != dir {
+			break
+		}
+		paths = append(paths, next)
+	}
+	return paths
+}
+
+// Whether the disk is a volume of a backend or sits in a disks directory
+func managedDisk(path string) bool {
+	path = filepath.Clean(path)
+	if _, ok := volumeBackend(path); ok {
+		return true
+	}
+	for _, dir := range Disks {
+		if strings.HasPrefix(path, filepath.Clean(dir)+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes a disk through the storage backend holding it, plain files
+// otherwise
 func (hv *HV) DeleteDiskFile(path string) error {
+	if backend, ok := volumeBackend(path); ok {
+		return backend.Delete(path)
+	}
+
 	if _, err := os.Stat(path); err != nil {
 		return errors.New("file does not exist")
 	}
@@ -0,0 +1,88 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BasedDevelopment/auto/internal/storage"
+)
+
+func TestManagedDisk(t *testing.T) {
+	backends["ssd"] = &storage.LVM{VolumeGroup: "vg0", ThinPool: "thin", Runner: nopRunner{}}
+	defer delete(backends, "ssd")
+	saved := Disks
+	Disks = []string{"/var/lib/auto/disks"}
+	defer func() { Disks = saved }()
+
+	for path, want := range map[string]bool{
+		"/dev/vg0/dom-0":                        true,
+		"/var/lib/auto/disks/dom/vda.qcow2":     true,
+		"/var/lib/auto/disks/../images/a.iso":   false,
+		"/var/lib/auto/disks-old/dom/vda.qcow2": false,
+		"/var/lib/libvirt/images/dom.qcow2":     false,
+		"/dev/sdb":                              false,
+	} {
+		if got := managedDisk(path); got != want {
+			t.Errorf("%s: got %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestDeleteDiskSkipsUnmanaged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dom.qcow2")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	res := new(HV).deleteDisk(path)
+	if !res.Skipped || res.OK {
+		t.Errorf("got %+v, want skipped", res)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("unmanaged disk was removed: %v", err)
+	}
+}
+
+// Answers qemu-img info with a canned backing chain
+type chainRunner string
+
+func (r chainRunner) Run(string, ...string) ([]byte, error) { return []byte(r), nil }
+
+func TestDiskChain(t *testing.T) {
+	chain := chainRunner(`[
+		{"filename": "/var/lib/auto/disks/dom/vda.snap2", "full-backing-filename": "/var/lib/auto/disks/dom/vda.snap1"},
+		{"filename": "/var/lib/auto/disks/dom/vda.snap1", "full-backing-filename": "/var/lib/auto/disks/dom/vda.qcow2"},
+		{"filename": "/var/lib/auto/disks/dom/vda.qcow2", "full-backing-filename": "/var/lib/auto/cloud-images/debian.qcow2"},
+		{"filename": "/var/lib/auto/cloud-images/debian.qcow2"}
+	]`)
+
+	got := diskChain(chain, "/var/lib/auto/disks/dom/vda.snap2")
+	want := []string{
+		"/var/lib/auto/disks/dom/vda.snap2",
+		"/var/lib/auto/disks/dom/vda.snap1",
+		"/var/lib/auto/disks/dom/vda.qcow2",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
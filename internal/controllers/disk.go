@@ -0,0 +1,261 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/storage"
+	"github.com/BasedDevelopment/auto/internal/util"
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	gib = 1 << 30
+	// How long guests get to release a device being unplugged
+	detachTimeout = 30 * time.Second
+)
+
+var (
+	ErrDiskNotFound = errors.New("disk not found")
+	ErrDiskShrink   = errors.New("disks can only grow")
+	ErrNoSpace      = errors.New("not enough free space in storage")
+)
+
+// Grows a disk to size GiB. Running domains see the new size right away,
+// stopped ones on their next boot.
+func (hv *HV) ResizeDisk(vm *models.VM, dev string, size int) (*models.VMStorage, error) {
+	if err := hv.ensureConn(); err != nil {
+		return nil, err
+	}
+
+	unlock, err := hv.LockDomain(vm.ID, "resize_disk")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	vm.Mutex.Lock()
+	disk, ok := vm.Storages[dev]
+	vm.Mutex.Unlock()
+	if !ok || disk.Device != "disk" || disk.Path == "" {
+		return nil, ErrDiskNotFound
+	}
+
+	_, capacity, _, err := hv.Libvirt.GetVMBlockInfo(vm.Domain, dev)
+	if err != nil {
+		return nil, err
+	}
+	bytes := uint64(size) * gib
+	if bytes <= capacity {
+		return nil, fmt.Errorf("%w: %s is already %d GiB", ErrDiskShrink, dev, capacity/gib)
+	}
+
+	// Disks outside of any storage are plain image files, their free space
+	// is that of the filesystem they're on
+	backend, owned := volumeBackend(disk.Path)
+	space := backend
+	if !owned {
+		space = &storage.FS{Dir: filepath.Dir(disk.Path)}
+	}
+	free, err := space.Free()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(free) < bytes-capacity {
+		return nil, fmt.Errorf("%w: %d GiB free", ErrNoSpace, free/gib)
+	}
+
+	active, err := hv.Libvirt.IsVMActive(vm.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	// Block devices are grown first either way, qemu grows files itself
+	// while it has them open
+	block := strings.HasPrefix(disk.Path, "/dev/")
+	if block || !active {
+		if owned {
+			err = backend.Resize(disk.Path, size)
+		} else {
+			err = storage.ResizeImage(storage.ExecRunner{}, disk.Path, size)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if active {
+		if err := hv.Libvirt.ResizeVMDisk(vm.Domain, dev, bytes); err != nil {
+			return nil, err
+		}
+	}
+
+	hv.fetchVMSpecs(vm)
+	return getVMStorage(vm, dev)
+}
+
+// Creates a disk in a storage and plugs it into the domain at the first
+// free virtio target, live as well when the domain is running
+func (hv *HV) AttachDisk(vm *models.VM, req *util.DiskAttachRequest) (*models.VMStorage, error) {
+	if err := hv.ensureConn(); err != nil {
+		return nil, err
+	}
+
+	unlock, err := hv.LockDomain(vm.ID, "attach_disk")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	backend, ok := diskBackend(req.Storage)
+	if !ok {
+		return nil, ErrStorageNotFound
+	}
+
+	vm.Mutex.Lock()
+	var dev string
+	for i := 0; ; i++ {
+		dev = libvirt.DiskTarget("vd", i)
+		if _, used := vm.Storages[dev]; !used {
+			break
+		}
+	}
+	vm.Mutex.Unlock()
+
+	active, err := hv.Libvirt.IsVMActive(vm.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	// Targets get reused once disks are detached, volume names don't
+	vol, err := backend.Create(vm.ID.String()+"/"+uuid.NewString()[:8], req.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	devXml, err := libvirt.DeviceXML("disk", libvirt.DomDisk{Path: vol.Path, Format: vol.Format, Block: vol.Block}.Def(dev))
+	if err == nil {
+		err = hv.Libvirt.AttachVMDevice(vm.Domain, devXml, active)
+	}
+	if err != nil {
+		if rmErr := backend.Delete(vol.Path); rmErr != nil {
+			log.Warn().
+				Err(rmErr).
+				Str("path", vol.Path).
+				Msg("failed to clean up after failed disk attach")
+		}
+		return nil, err
+	}
+
+	hv.fetchVMSpecs(vm)
+	return getVMStorage(vm, dev)
+}
+
+// Unplugs a disk, live as well when the domain is running, and removes its
+// volume unless storage is keep
+func (hv *HV) DetachDisk(vm *models.VM, dev string, storage string) (res models.DiskDetachResult, err error) {
+	res.Dev = dev
+
+	if err := hv.ensureConn(); err != nil {
+		return res, err
+	}
+
+	unlock, err := hv.LockDomain(vm.ID, "detach_disk")
+	if err != nil {
+		return res, err
+	}
+	defer unlock()
+
+	specs, err := hv.Libvirt.GetVMSpecs(vm.Domain)
+	if err != nil {
+		return res, err
+	}
+
+	active, err := hv.Libvirt.IsVMActive(vm.Domain)
+	if err != nil {
+		return res, err
+	}
+
+	for _, disk := range specs.Devices.Disk {
+		if disk.Target.Dev != dev || disk.Device != "disk" {
+			continue
+		}
+
+		d := libvirt.DomDisk{Path: disk.Source.File, Format: disk.Driver.Type}
+		if disk.Type == "block" {
+			d.Path = disk.Source.Dev
+			d.Block = true
+		}
+		def := d.Def(dev)
+		def.Target.Bus = disk.Target.Bus
+		devXml, err := libvirt.DeviceXML("disk", def)
+		if err != nil {
+			return res, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), detachTimeout)
+		defer cancel()
+		err = hv.Libvirt.DetachVMDevice(ctx, vm.Domain, devXml, disk.Alias.Name, active)
+		hv.fetchVMSpecs(vm)
+		res.Steps = append(res.Steps, deleteStep("detach", "", err))
+		if err != nil {
+			return res, err
+		}
+
+		// Only reached once the disk is gone from the domain and its
+		// config, one the guest still holds never gets deleted
+		if storage == DeleteStorageDisks && d.Path != "" {
+			res.Steps = append(res.Steps, hv.deleteDisk(d.Path))
+		}
+		return res, nil
+	}
+
+	return res, ErrDiskNotFound
+}
+
+func getVMStorage(vm *models.VM, dev string) (*models.VMStorage, error) {
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+	disk, ok := vm.Storages[dev]
+	if !ok {
+		return nil, ErrDiskNotFound
+	}
+	return &models.VMStorage{
+		ID:         disk.ID,
+		Path:       disk.Path,
+		Device:     disk.Device,
+		TargetDev:  disk.TargetDev,
+		Bus:        disk.Bus,
+		Format:     disk.Format,
+		Size:       disk.Size,
+		Allocation: disk.Allocation,
+		Created:    disk.Created,
+		Updated:    disk.Updated,
+		Remarks:    disk.Remarks,
+	}, nil
+}
@@ -0,0 +1,95 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// Subscribe to libvirt lifecycle events and keep HV.VMs in sync with them.
+// The subscription ends with ctx or when the libvirt connection is lost.
+func (hv *HV) WatchEvents(ctx context.Context) error {
+	if err := hv.ensureConn(); err != nil {
+		return err
+	}
+
+	events, err := hv.Libvirt.LifecycleEvents(ctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for ev := range events {
+			hv.handleEvent(ev)
+		}
+		log.Warn().Msg("Libvirt lifecycle event stream closed")
+	}()
+
+	return nil
+}
+
+func (hv *HV) handleEvent(ev libvirt.DomEvent) {
+	log.Debug().
+		Str("domain", ev.ID.String()).
+		Str("event", ev.String()).
+		Int32("detail", ev.Detail).
+		Msg("lifecycle event")
+
+	// Undefining a running domain leaves it transient and stopping a transient
+	// domain makes it vanish, so ask libvirt whether it's still around
+	if ev.IsUndefined() || ev.IsStopped() {
+		if _, err := hv.Libvirt.GetVMFromUUID(ev.ID); err != nil {
+			hv.Mutex.Lock()
+			delete(hv.VMs, ev.ID)
+			hv.Mutex.Unlock()
+			hv.removeFirewall(ev.ID)
+			return
+		}
+	}
+
+	hv.Mutex.Lock()
+	vm, ok := hv.VMs[ev.ID]
+	if !ok {
+		vm = &models.VM{ID: ev.ID, Domain: ev.Dom}
+		hv.VMs[ev.ID] = vm
+	}
+	hv.Mutex.Unlock()
+
+	// New domain or new config, everything has to be refetched. Started
+	// domains get new tap devices.
+	if !ok || ev.IsDefined() || ev.IsStarted() {
+		hv.refreshVM(vm)
+		return
+	}
+
+	if err := hv.refreshVMState(vm); err != nil {
+		log.Error().
+			Err(err).
+			Str("domain", ev.ID.String()).
+			Msg("Failed to get VM state")
+	}
+
+	if ev.IsStopped() {
+		hv.refreshFirewall(vm)
+	}
+}
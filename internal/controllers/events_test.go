@@ -0,0 +1,105 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/pkg/models"
+	golibvirt "github.com/digitalocean/go-libvirt"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// HV whose libvirt is never reachable, enough for the bookkeeping around it
+func testHV(t *testing.T) *HV {
+	t.Helper()
+	conn, err := libvirt.Init("unix://"+t.TempDir()+"/libvirt-sock", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &HV{Libvirt: conn, VMs: make(map[uuid.UUID]*models.VM)}
+}
+
+// Events keep adding and dropping domains while the routes look them up,
+// run with -race to catch unlocked map access
+func TestHandleEventConcurrentLookup(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	defer zerolog.SetGlobalLevel(zerolog.TraceLevel)
+
+	hv := testHV(t)
+	ids := make([]uuid.UUID, 8)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			for _, id := range ids {
+				hv.handleEvent(libvirt.DomEvent{ID: id, Event: golibvirt.DomainEventDefined})
+				// libvirt can't be asked, so stopped domains are gone
+				hv.handleEvent(libvirt.DomEvent{ID: id, Event: golibvirt.DomainEventStopped})
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			for _, vm := range hv.ListVMs() {
+				if _, ok := hv.LookupVM(vm.ID); ok && vm.ID == uuid.Nil {
+					t.Error("unexpected nil ID")
+				}
+			}
+		}
+	}()
+	wg.Wait()
+
+	if vms := hv.ListVMs(); len(vms) != 0 {
+		t.Errorf("expected every domain to be dropped, %d left", len(vms))
+	}
+}
+
+func TestListVMsSorted(t *testing.T) {
+	hv := testHV(t)
+	for i := 0; i < 5; i++ {
+		id := uuid.New()
+		hv.VMs[id] = &models.VM{ID: id}
+	}
+
+	vms := hv.ListVMs()
+	if len(vms) != 5 {
+		t.Fatalf("expected 5 domains, got %d", len(vms))
+	}
+	for i := 1; i < len(vms); i++ {
+		if vms[i-1].ID.String() > vms[i].ID.String() {
+			t.Errorf("domains not sorted at %d", i)
+		}
+	}
+
+	// The list is a copy, changing it leaves the HV alone
+	vms[0] = nil
+	if vm, ok := hv.LookupVM(hv.ListVMs()[0].ID); !ok || vm == nil {
+		t.Error("lookup failed after changing the list")
+	}
+}
@@ -0,0 +1,254 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BasedDevelopment/auto/internal/config"
+	"github.com/BasedDevelopment/auto/internal/firewall"
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/util"
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrFirewallDisabled = errors.New("firewall is not enabled")
+	// Rules that passed request validation but still can't be rendered
+	ErrInvalidFirewall = errors.New("invalid firewall rules")
+)
+
+var (
+	// nil unless enabled in the config
+	fw *firewall.Firewall
+	// Saving and applying rulesets, events and requests race otherwise
+	fwMutex sync.Mutex
+)
+
+// Sets up the firewall from the config, before the domains are loaded so
+// their rulesets get applied right away
+func (hv *HV) CheckFirewall() error {
+	if !config.Config.Firewall.Enabled {
+		return nil
+	}
+
+	// nft only reaches the tap devices of this host
+	if uri, _ := libvirt.ParseURI(hv.URI); uri.Transport != libvirt.TransportUnix {
+		return errors.New("the firewall needs libvirt on this host")
+	}
+
+	if err := os.MkdirAll(config.Config.Firewall.Path, 0700); err != nil {
+		return err
+	}
+
+	fw = &firewall.Firewall{Dir: config.Config.Firewall.Path, Runner: firewall.ExecRunner{}}
+	return nil
+}
+
+func firewallRulesPath(id uuid.UUID) string {
+	return filepath.Join(fw.Dir, id.String()+".json")
+}
+
+// Rules of a domain, domains without any accept everything but spoofed
+// traffic
+func loadFirewall(id uuid.UUID) (models.VMFirewall, error) {
+	var rules models.VMFirewall
+	data, err := os.ReadFile(firewallRulesPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return models.VMFirewall{
+			IngressPolicy: firewall.Accept,
+			EgressPolicy:  firewall.Accept,
+			Addresses:     map[string][]string{},
+			Rules:         []models.VMFirewallRule{},
+		}, nil
+	}
+	if err != nil {
+		return rules, err
+	}
+	err = json.Unmarshal(data, &rules)
+	return rules, err
+}
+
+func (hv *HV) GetFirewall(vm *models.VM) (models.VMFirewall, error) {
+	if fw == nil {
+		return models.VMFirewall{}, ErrFirewallDisabled
+	}
+
+	fwMutex.Lock()
+	defer fwMutex.Unlock()
+	return loadFirewall(vm.ID)
+}
+
+// Replaces the rules of a domain and applies them if it's running
+func (hv *HV) SetFirewall(vm *models.VM, req *util.FirewallRequest) (models.VMFirewall, error) {
+	if fw == nil {
+		return models.VMFirewall{}, ErrFirewallDisabled
+	}
+
+	rules := models.VMFirewall{
+		IngressPolicy: req.IngressPolicy,
+		EgressPolicy:  req.EgressPolicy,
+		Addresses:     map[string][]string{},
+		Rules:         []models.VMFirewallRule{},
+		Updated:       time.Now(),
+	}
+	if rules.IngressPolicy == "" {
+		rules.IngressPolicy = firewall.Accept
+	}
+	if rules.EgressPolicy == "" {
+		rules.EgressPolicy = firewall.Accept
+	}
+	// MACs are keyed the way libvirt writes them
+	for mac, addrs := range req.Addresses {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			return models.VMFirewall{}, fmt.Errorf("%w: %v", ErrInvalidFirewall, err)
+		}
+		rules.Addresses[hw.String()] = addrs
+	}
+	for _, r := range req.Rules {
+		rules.Rules = append(rules.Rules, models.VMFirewallRule(r))
+	}
+
+	fwMutex.Lock()
+	defer fwMutex.Unlock()
+
+	// Render before saving so broken rules never make it to disk
+	if _, err := firewall.Render(firewallRuleset(vm, rules)); err != nil {
+		return models.VMFirewall{}, fmt.Errorf("%w: %v", ErrInvalidFirewall, err)
+	}
+
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return models.VMFirewall{}, err
+	}
+	if err := os.WriteFile(firewallRulesPath(vm.ID), data, 0600); err != nil {
+		return models.VMFirewall{}, err
+	}
+
+	return rules, hv.syncFirewall(vm)
+}
+
+// Ruleset of the NICs the domain has right now
+func firewallRuleset(vm *models.VM, rules models.VMFirewall) firewall.Ruleset {
+	rs := firewall.Ruleset{
+		ID:            vm.ID,
+		IngressPolicy: rules.IngressPolicy,
+		EgressPolicy:  rules.EgressPolicy,
+	}
+	for _, r := range rules.Rules {
+		rs.Rules = append(rs.Rules, firewall.Rule(r))
+	}
+
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+	for _, nic := range vm.Nics {
+		if nic.TargetDev == "" {
+			continue
+		}
+		rs.NICs = append(rs.NICs, firewall.NIC{
+			Tap:       nic.TargetDev,
+			MAC:       nic.MAC,
+			Addresses: rules.Addresses[strings.ToLower(nic.MAC)],
+		})
+	}
+	return rs
+}
+
+// Applies the ruleset of a running domain, or drops it if it's not running
+// since its tap devices can be handed to another domain. Callers hold
+// fwMutex.
+func (hv *HV) syncFirewall(vm *models.VM) error {
+	if err := hv.ensureConn(); err != nil {
+		return err
+	}
+
+	active, err := hv.Libvirt.IsVMActive(vm.Domain)
+	if err != nil {
+		return err
+	}
+	if !active {
+		return fw.Remove(vm.ID)
+	}
+
+	rules, err := loadFirewall(vm.ID)
+	if err != nil {
+		return err
+	}
+	return fw.Apply(firewallRuleset(vm, rules))
+}
+
+// Syncs the firewall of a domain after its state or devices changed
+func (hv *HV) refreshFirewall(vm *models.VM) {
+	if fw == nil {
+		return
+	}
+
+	fwMutex.Lock()
+	defer fwMutex.Unlock()
+	if err := hv.syncFirewall(vm); err != nil {
+		log.Error().
+			Err(err).
+			Str("domain", vm.ID.String()).
+			Msg("Failed to apply firewall")
+	}
+}
+
+// Drops the ruleset of a domain libvirt no longer knows, its rules stay
+func (hv *HV) removeFirewall(id uuid.UUID) {
+	if fw == nil {
+		return
+	}
+
+	fwMutex.Lock()
+	defer fwMutex.Unlock()
+	if err := fw.Remove(id); err != nil {
+		log.Error().
+			Err(err).
+			Str("domain", id.String()).
+			Msg("Failed to remove firewall")
+	}
+}
+
+// Drops the ruleset of a deleted domain along with its rules
+func (hv *HV) deleteFirewall(id uuid.UUID) error {
+	if fw == nil {
+		return nil
+	}
+
+	fwMutex.Lock()
+	defer fwMutex.Unlock()
+	if err := fw.Remove(id); err != nil {
+		return err
+	}
+	if err := os.Remove(firewallRulesPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -19,6 +19,10 @@
 package controllers
 
 import (
+	"context"
+	"encoding/json"
+	"time"
+
 	"github.com/BasedDevelopment/auto/internal/libvirt"
 	"github.com/BasedDevelopment/auto/pkg/models"
 	"github.com/BasedDevelopment/eve/pkg/status"
@@ -30,18 +34,22 @@ var Hypervisor = &HV{}
 
 type HV models.HV
 
-func (hv *HV) Init() error {
-	hv.Libvirt = libvirt.Init(hv.IP, hv.Port)
+// Initializes the HV and connects to libvirt, the connection is then
+// supervised until ctx is done and re-established whenever it is lost
+func (hv *HV) Init(ctx context.Context) error {
+	conn, err := libvirt.Init(hv.URI, hv.TLSConfig)
+	if err != nil {
+		return err
+	}
+	hv.Libvirt = conn
 	hv.Brs = make(map[string]*models.HVBr)
 	hv.Storages = make(map[string]*models.HVStorage)
 	hv.VMs = make(map[uuid.UUID]*models.VM)
-	if err := hv.ensureConn(); err != nil {
-		return err
-	}
-	if err := hv.InitVMs(); err != nil {
-		return err
-	}
-	return nil
+	hv.updateStatus(status.StatusUnknown, "Connecting to libvirt")
+
+	err = hv.resync(ctx)
+	go hv.supervise(ctx)
+	return err
 }
 
 // Get the HV specs
@@ -108,16 +116,11 @@ func (hv *HV) getHVBrs() error {
 		return err
 	}
 
-	brs, err := hv.Libvirt.GetHVBrs()
+	brs, err := hv.listBrs()
 	if err != nil {
 		return err
 	}
-
-	for _, br := range brs {
-		hv.Brs[br.Name] = &models.HVBr{
-			Name: br.Name,
-		}
-	}
+	hv.Brs = brs
 	return nil
 }
 
@@ -134,14 +137,17 @@ func (hv *HV) connect() error {
 	hv.Mutex.Lock()
 	defer hv.Mutex.Unlock()
 
+	// Someone else may have connected while we were waiting on the lock
+	if hv.Libvirt.IsConnected() {
+		return nil
+	}
+
 	err := hv.Libvirt.Connect()
 	if err != nil {
-		hv.Status = status.StatusUnknown
-		hv.StatusReason = err.Error()
+		hv.setStatus(status.StatusUnknown, err.Error())
 		return err
 	} else {
-		hv.Status = status.StatusRunning
-		hv.StatusReason = "Connected to libvirt"
+		hv.setStatus(status.StatusRunning, "Connected to libvirt")
 	}
 	if err := hv.getHVSpecs(); err != nil {
 		return err
@@ -154,3 +160,32 @@ func (hv *HV) connect() error {
 	}
 	return nil
 }
+
+// Record a connection state transition, hv.Mutex has to be held
+func (hv *HV) setStatus(s status.Status, reason string) {
+	if hv.Status != s {
+		log.Info().
+			Int("from", int(hv.Status)).
+			Int("to", int(s)).
+			Str("reason", reason).
+			Msg("Hypervisor status changed")
+	}
+	hv.Status = s
+	hv.StatusReason = reason
+	hv.StatusUpdated = time.Now()
+}
+
+// setStatus for callers not holding hv.Mutex
+func (hv *HV) updateStatus(s status.Status, reason string) {
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+	hv.setStatus(s, reason)
+}
+
+// The HV as the API shows it, the connection status changes from the
+// supervisor so it's read under hv.Mutex
+func (hv *HV) MarshalJSON() ([]byte, error) {
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+	return json.Marshal((*models.HV)(hv))
+}
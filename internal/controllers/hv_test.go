@@ -0,0 +1,68 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/BasedDevelopment/eve/pkg/status"
+	"github.com/rs/zerolog"
+)
+
+// The supervisor records status changes while the API serializes the HV,
+// run with -race to catch unlocked access
+func TestStatusConcurrentMarshal(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	defer zerolog.SetGlobalLevel(zerolog.TraceLevel)
+
+	hv := testHV(t)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			hv.updateStatus(status.StatusRunning, "Connected to libvirt")
+			hv.updateStatus(status.StatusUnknown, "Lost connection to libvirt")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			if _, err := json.Marshal(hv); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	b, err := json.Marshal(hv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		StatusReason string `json:"status_reason"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil || got.StatusReason != "Lost connection to libvirt" {
+		t.Errorf("got %q, %v", got.StatusReason, err)
+	}
+}
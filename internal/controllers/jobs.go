@@ -0,0 +1,207 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// How long finished jobs stay around to be polled
+const jobRetention = 1 * time.Hour
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrDomainBusy  = errors.New("domain is busy with another operation")
+	ErrFileBusy    = errors.New("file is busy with another operation")
+)
+
+// Reports the progress of a job, in percent
+type JobProgress func(percent int)
+
+type job struct {
+	mu  sync.Mutex
+	job models.Job
+}
+
+var jobs = struct {
+	sync.Mutex
+	m map[uuid.UUID]*job
+	// Operation holding each domain, only one at a time
+	locks map[uuid.UUID]string
+	// Same for files, by path
+	files map[string]string
+}{
+	m:     make(map[uuid.UUID]*job),
+	locks: make(map[uuid.UUID]string),
+	files: make(map[string]string),
+}
+
+// Takes the operation lock on a domain, failing with ErrDomainBusy if another
+// operation holds it. The returned func releases the lock.
+func (hv *HV) LockDomain(domID uuid.UUID, op string) (func(), error) {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	if held, ok := jobs.locks[domID]; ok {
+		return nil, fmt.Errorf("%w: %s in progress", ErrDomainBusy, held)
+	}
+	jobs.locks[domID] = op
+
+	return func() {
+		jobs.Lock()
+		defer jobs.Unlock()
+		delete(jobs.locks, domID)
+	}, nil
+}
+
+// Runs fn in the background under the domain lock and returns the job
+// tracking it. The job is not tied to any request so it keeps going if the
+// client goes away.
+func (hv *HV) StartJob(kind string, domID uuid.UUID, fn func(progress JobProgress) (interface{}, error)) (models.Job, error) {
+	unlock, err := hv.LockDomain(domID, kind)
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	return startJob(models.Job{Type: kind, Domain: domID}, unlock, fn), nil
+}
+
+// Same as StartJob for jobs working on a file rather than a domain
+func (hv *HV) StartFileJob(kind string, path string, fn func(progress JobProgress) (interface{}, error)) (models.Job, error) {
+	unlock, err := hv.LockFile(path, kind)
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	return startJob(models.Job{Type: kind, Path: path}, unlock, fn), nil
+}
+
+// Takes the operation lock on a file, like LockDomain
+func (hv *HV) LockFile(path string, op string) (func(), error) {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	if held, ok := jobs.files[path]; ok {
+		return nil, fmt.Errorf("%w: %s in progress", ErrFileBusy, held)
+	}
+	jobs.files[path] = op
+
+	return func() {
+		jobs.Lock()
+		defer jobs.Unlock()
+		delete(jobs.files, path)
+	}, nil
+}
+
+func startJob(spec models.Job, unlock func(), fn func(progress JobProgress) (interface{}, error)) models.Job {
+	spec.ID = uuid.New()
+	spec.Status = models.JobPending
+	spec.Created = time.Now()
+	j := &job{job: spec}
+
+	jobs.Lock()
+	pruneJobs()
+	jobs.m[j.job.ID] = j
+	jobs.Unlock()
+
+	go func() {
+		defer unlock()
+
+		j.mu.Lock()
+		j.job.Status = models.JobRunning
+		j.job.Started = time.Now()
+		j.mu.Unlock()
+
+		result, err := fn(func(percent int) {
+			j.mu.Lock()
+			defer j.mu.Unlock()
+			j.job.Progress = percent
+		})
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.job.Finished = time.Now()
+		j.job.Result = result
+		if err != nil {
+			j.job.Status = models.JobFailed
+			j.job.Error = err.Error()
+			log.Error().
+				Err(err).
+				Str("job", j.job.ID.String()).
+				Str("type", spec.Type).
+				Str("domain", spec.Domain.String()).
+				Str("path", spec.Path).
+				Msg("job failed")
+			return
+		}
+		j.job.Status = models.JobSucceeded
+		j.job.Progress = 100
+	}()
+
+	return j.snapshot()
+}
+
+func (hv *HV) GetJob(id uuid.UUID) (models.Job, error) {
+	jobs.Lock()
+	j, ok := jobs.m[id]
+	jobs.Unlock()
+	if !ok {
+		return models.Job{}, ErrJobNotFound
+	}
+	return j.snapshot(), nil
+}
+
+// All known jobs, oldest first
+func (hv *HV) GetJobs() []models.Job {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	list := make([]models.Job, 0, len(jobs.m))
+	for _, j := range jobs.m {
+		list = append(list, j.snapshot())
+	}
+	sort.Slice(list, func(i, k int) bool {
+		return list[i].Created.Before(list[k].Created)
+	})
+	return list
+}
+
+func (j *job) snapshot() models.Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.job
+}
+
+// Drops finished jobs past their retention, jobs must be locked
+func pruneJobs() {
+	for id, j := range jobs.m {
+		s := j.snapshot()
+		if !s.Finished.IsZero() && time.Since(s.Finished) > jobRetention {
+			delete(jobs.m, id)
+		}
+	}
+}
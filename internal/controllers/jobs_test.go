@@ -0,0 +1,159 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/google/uuid"
+)
+
+// Waits for a job to leave the pending and running states
+func waitJob(t *testing.T, hv *HV, id uuid.UUID) models.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		j, err := hv.GetJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status != models.JobPending && j.Status != models.JobRunning {
+			return j
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("job did not finish")
+	return models.Job{}
+}
+
+func TestJobLockContention(t *testing.T) {
+	hv := &HV{}
+	domID := uuid.New()
+	release := make(chan struct{})
+
+	job, err := hv.StartJob("migrate", domID, func(progress JobProgress) (interface{}, error) {
+		<-release
+		return "done", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		try  func() error
+		want error
+	}{
+		{"job on the same domain", func() error {
+			_, err := hv.StartJob("delete_domain", domID, func(JobProgress) (interface{}, error) { return nil, nil })
+			return err
+		}, ErrDomainBusy},
+		{"lock on the same domain", func() error {
+			_, err := hv.LockDomain(domID, "update_domain")
+			return err
+		}, ErrDomainBusy},
+		{"lock on another domain", func() error {
+			unlock, err := hv.LockDomain(uuid.New(), "update_domain")
+			if err == nil {
+				unlock()
+			}
+			return err
+		}, nil},
+	} {
+		if err := tc.try(); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	// The job holds the lock until it's done
+	close(release)
+	if j := waitJob(t, hv, job.ID); j.Status != models.JobSucceeded || j.Result != "done" || j.Progress != 100 {
+		t.Errorf("unexpected job %+v", j)
+	}
+	unlock, err := hv.LockDomain(domID, "update_domain")
+	if err != nil {
+		t.Fatalf("lock not released after the job: %v", err)
+	}
+	unlock()
+}
+
+func TestFileLockContention(t *testing.T) {
+	hv := &HV{}
+	path := "/var/lib/auto/images/" + uuid.NewString() + ".iso"
+
+	unlock, err := hv.LockFile(path, "upload_image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hv.StartFileJob("fetch_image", path, func(JobProgress) (interface{}, error) { return nil, nil }); !errors.Is(err, ErrFileBusy) {
+		t.Errorf("expected ErrFileBusy, got %v", err)
+	}
+	unlock()
+
+	job, err := hv.StartFileJob("fetch_image", path, func(JobProgress) (interface{}, error) {
+		return nil, errors.New("unreachable")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j := waitJob(t, hv, job.ID); j.Status != models.JobFailed || j.Error != "unreachable" {
+		t.Errorf("unexpected job %+v", j)
+	}
+}
+
+func TestPruneJobs(t *testing.T) {
+	hv := &HV{}
+	now := time.Now()
+
+	add := func(finished time.Time) uuid.UUID {
+		id := uuid.New()
+		jobs.Lock()
+		jobs.m[id] = &job{job: models.Job{ID: id, Created: now, Finished: finished}}
+		jobs.Unlock()
+		return id
+	}
+
+	for _, tc := range []struct {
+		name     string
+		finished time.Time
+		kept     bool
+	}{
+		{"running", time.Time{}, true},
+		{"just finished", now, true},
+		{"within retention", now.Add(-jobRetention + time.Minute), true},
+		{"past retention", now.Add(-jobRetention - time.Minute), false},
+	} {
+		id := add(tc.finished)
+
+		jobs.Lock()
+		pruneJobs()
+		jobs.Unlock()
+
+		_, err := hv.GetJob(id)
+		if kept := err == nil; kept != tc.kept {
+			t.Errorf("%s: kept %v, want %v", tc.name, kept, tc.kept)
+		}
+		if err != nil && !errors.Is(err, ErrJobNotFound) {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+	}
+}
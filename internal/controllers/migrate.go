@@ -0,0 +1,198 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/util"
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// How often a running migration is polled for progress
+const migrationPoll = 1 * time.Second
+
+var (
+	ErrPeerNotFound = errors.New("peer not found")
+	ErrNotMigrating = errors.New("domain is not being migrated")
+	// Offline migration leaves the disks here, the peer has to share them
+	ErrOfflineNotShared = errors.New("shut off domains can only be migrated to peers with shared storage")
+)
+
+// Peer each domain is being migrated to
+var migrations = struct {
+	sync.Mutex
+	m map[uuid.UUID]string
+}{m: make(map[uuid.UUID]string)}
+
+// Migrates the domain to a peer as a job. The peer picks the domain up
+// through its own lifecycle events, it's dropped here once libvirt is done.
+func (hv *HV) MigrateDomain(vm *models.VM, req *util.DomainMigrateRequest) (models.Job, error) {
+	if err := hv.ensureConn(); err != nil {
+		return models.Job{}, err
+	}
+
+	peer, ok := hv.Peers[req.Peer]
+	if !ok {
+		return models.Job{}, ErrPeerNotFound
+	}
+
+	active, err := hv.Libvirt.IsVMActive(vm.Domain)
+	if err != nil {
+		return models.Job{}, err
+	}
+	if !active {
+		if req.CopyStorage {
+			return models.Job{}, libvirt.ErrOfflineCopyStorage
+		}
+		if !peer.SharedStorage {
+			return models.Job{}, ErrOfflineNotShared
+		}
+	}
+
+	opts := libvirt.MigrateOptions{
+		Live:        req.Live,
+		CopyStorage: req.CopyStorage,
+		Bandwidth:   uint64(req.Bandwidth),
+	}
+
+	return hv.StartJob("migrate", vm.ID, func(progress JobProgress) (interface{}, error) {
+		migrations.Lock()
+		migrations.m[vm.ID] = peer.Name
+		migrations.Unlock()
+		defer func() {
+			migrations.Lock()
+			delete(migrations.m, vm.ID)
+			migrations.Unlock()
+		}()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- hv.Libvirt.MigrateVM(vm.Domain, peer.URI, opts)
+		}()
+
+		res := models.VMMigration{Peer: peer.Name}
+		ticker := time.NewTicker(migrationPoll)
+		defer ticker.Stop()
+		for {
+			select {
+			case err := <-done:
+				if err != nil {
+					return nil, err
+				}
+				hv.dropMigratedVM(vm)
+				return res, nil
+			case <-ticker.C:
+				stats, err := hv.Libvirt.GetVMJobStats(vm.Domain)
+				if err != nil || !stats.Active() {
+					continue
+				}
+				res = migrationStats(peer.Name, stats)
+				if stats.DataTotal == 0 {
+					continue
+				}
+				// 100 is left for when libvirt reports completion
+				percent := int(stats.DataProcessed * 100 / stats.DataTotal)
+				if percent > 99 {
+					percent = 99
+				}
+				progress(percent)
+			}
+		}
+	})
+}
+
+// Live statistics of the migration running on the domain
+func (hv *HV) GetMigration(vm *models.VM) (models.VMMigration, error) {
+	peer, err := migratingTo(vm)
+	if err != nil {
+		return models.VMMigration{}, err
+	}
+
+	if err := hv.ensureConn(); err != nil {
+		return models.VMMigration{}, err
+	}
+
+	stats, err := hv.Libvirt.GetVMJobStats(vm.Domain)
+	if err != nil {
+		return models.VMMigration{}, err
+	}
+	return migrationStats(peer, stats), nil
+}
+
+// Aborts the migration, the domain keeps running here
+func (hv *HV) CancelMigration(vm *models.VM) error {
+	if _, err := migratingTo(vm); err != nil {
+		return err
+	}
+
+	if err := hv.ensureConn(); err != nil {
+		return err
+	}
+
+	return hv.Libvirt.AbortVMJob(vm.Domain)
+}
+
+func migratingTo(vm *models.VM) (string, error) {
+	migrations.Lock()
+	defer migrations.Unlock()
+
+	peer, ok := migrations.m[vm.ID]
+	if !ok {
+		return "", ErrNotMigrating
+	}
+	return peer, nil
+}
+
+// The source domain is undefined by libvirt, but the lifecycle event may
+// not have made it here yet
+func (hv *HV) dropMigratedVM(vm *models.VM) {
+	if _, err := hv.Libvirt.GetVMFromUUID(vm.ID); err == nil {
+		log.Warn().
+			Str("domain", vm.ID.String()).
+			Msg("domain still defined after migration")
+		return
+	}
+
+	hv.Mutex.Lock()
+	delete(hv.VMs, vm.ID)
+	hv.Mutex.Unlock()
+}
+
+func migrationStats(peer string, stats libvirt.JobStats) models.VMMigration {
+	return models.VMMigration{
+		Peer:            peer,
+		Active:          stats.Active(),
+		TimeElapsed:     stats.TimeElapsed,
+		TimeRemaining:   stats.TimeRemaining,
+		DataTotal:       stats.DataTotal,
+		DataProcessed:   stats.DataProcessed,
+		DataRemaining:   stats.DataRemaining,
+		MemoryRemaining: stats.MemoryRemaining,
+		MemoryBps:       stats.MemoryBps,
+		DiskRemaining:   stats.DiskRemaining,
+		Iteration:       stats.Iteration,
+		Downtime:        stats.Downtime,
+	}
+}
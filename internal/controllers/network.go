@@ -0,0 +1,178 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/BasedDevelopment/auto/internal/config"
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/util"
+	"github.com/BasedDevelopment/auto/pkg/models"
+)
+
+// Network types, plain Linux bridges or Open vSwitch ones
+const (
+	NetworkBridge = "bridge"
+	NetworkOVS    = "ovs"
+)
+
+var (
+	ErrNetworkNotFound = errors.New("network not found")
+	ErrUnknownBridge   = errors.New("bridge is not a configured network")
+	ErrVLANNeedsOVS    = errors.New("VLANs need an ovs network")
+)
+
+// Bridges of the enabled networks in the config, with the state libvirt
+// reports for them. Bridges libvirt doesn't list as active are down.
+func (hv *HV) listBrs() (map[string]*models.HVBr, error) {
+	nics, err := hv.Libvirt.GetHVBrs()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	brs := make(map[string]*models.HVBr)
+	for name, cfg := range config.Config.Network {
+		if !cfg.Enabled {
+			continue
+		}
+		brs[name] = &models.HVBr{
+			Name:      name,
+			Type:      cfg.Type,
+			State:     "down",
+			Addresses: []string{},
+			Ports:     []string{},
+			Updated:   now,
+			Remarks:   cfg.Remarks,
+		}
+	}
+
+	for _, nic := range nics {
+		br, ok := brs[nic.Name]
+		if !ok {
+			continue
+		}
+		br.Active = true
+		br.State = nic.Link.State
+		if br.State == "" {
+			br.State = "up"
+		}
+		br.Speed, _ = strconv.Atoi(nic.Link.Speed)
+		br.MAC = nic.Mac.Address
+		for _, proto := range nic.Protocol {
+			for _, ip := range proto.Ip {
+				br.Addresses = append(br.Addresses, ip.Address+"/"+ip.Prefix)
+			}
+		}
+		for _, port := range nic.Bridge.Interface {
+			br.Ports = append(br.Ports, port.Name)
+		}
+	}
+
+	return brs, nil
+}
+
+// All configured networks with fresh state, by name
+func (hv *HV) GetNetworks() ([]*models.HVBr, error) {
+	if err := hv.ensureConn(); err != nil {
+		return nil, err
+	}
+
+	brs, err := hv.listBrs()
+	if err != nil {
+		return nil, err
+	}
+
+	hv.Mutex.Lock()
+	hv.Brs = brs
+	hv.Mutex.Unlock()
+
+	list := make([]*models.HVBr, 0, len(brs))
+	for _, br := range brs {
+		list = append(list, br)
+	}
+	sort.Slice(list, func(i, k int) bool {
+		return list[i].Name < list[k].Name
+	})
+	return list, nil
+}
+
+func (hv *HV) GetNetwork(name string) (*models.HVBr, error) {
+	brs, err := hv.GetNetworks()
+	if err != nil {
+		return nil, err
+	}
+	for _, br := range brs {
+		if br.Name == name {
+			return br, nil
+		}
+	}
+	return nil, ErrNetworkNotFound
+}
+
+// NIC for a request, domains may only be plugged into the enabled networks
+// of the config and only OVS ones carry VLANs
+func domNic(req util.IfaceRequest) (libvirt.DomNic, error) {
+	cfg, ok := config.Config.Network[req.Bridge]
+	if !ok || !cfg.Enabled {
+		return libvirt.DomNic{}, fmt.Errorf("%w: %q", ErrUnknownBridge, req.Bridge)
+	}
+
+	ovs := cfg.Type == NetworkOVS
+	if !ovs && (req.VLAN != 0 || len(req.Trunk) > 0) {
+		return libvirt.DomNic{}, fmt.Errorf("%w: %q", ErrVLANNeedsOVS, req.Bridge)
+	}
+
+	return libvirt.DomNic{
+		Bridge:    req.Bridge,
+		MAC:       req.MAC,
+		Model:     req.Model,
+		OVS:       ovs,
+		VLAN:      req.VLAN,
+		Trunk:     req.Trunk,
+		Queues:    req.Queues,
+		Bandwidth: domBandwidth(req.Bandwidth),
+	}, nil
+}
+
+// Limits are validated to fit in uint32
+func domBandwidth(req util.BandwidthRequest) libvirt.DomBandwidth {
+	limit := func(l util.BandwidthLimit) libvirt.DomBandwidthLimit {
+		return libvirt.DomBandwidthLimit{
+			Average: uint32(l.Average),
+			Peak:    uint32(l.Peak),
+			Burst:   uint32(l.Burst),
+		}
+	}
+	return libvirt.DomBandwidth{
+		Inbound:  limit(req.Inbound),
+		Outbound: limit(req.Outbound),
+	}
+}
+
+// Checks a NIC request against the networks of the config
+func CheckIface(req util.IfaceRequest) error {
+	_, err := domNic(req)
+	return err
+}
@@ -0,0 +1,207 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/util"
+	"github.com/BasedDevelopment/auto/pkg/models"
+)
+
+var (
+	ErrNicNotFound = errors.New("nic not found")
+	ErrNicExists   = errors.New("nic with that MAC already exists")
+)
+
+// NIC of a domain by MAC, however the MAC is written
+func vmNic(vm *models.VM, mac string) (string, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return "", ErrNicNotFound
+	}
+
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+	for key := range vm.Nics {
+		if k, err := net.ParseMAC(key); err == nil && k.String() == hw.String() {
+			return key, nil
+		}
+	}
+	return "", ErrNicNotFound
+}
+
+// Applies the changes to a NIC, live as well when the domain is running
+func (hv *HV) UpdateNic(vm *models.VM, mac string, req *util.NicUpdateRequest) (*models.VMNic, error) {
+	if err := hv.ensureConn(); err != nil {
+		return nil, err
+	}
+
+	unlock, err := hv.LockDomain(vm.ID, "update_nic")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	mac, err = vmNic(vm, mac)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := hv.Libvirt.IsVMActive(vm.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Bandwidth != nil {
+		if err := hv.Libvirt.SetVMNicBandwidth(vm.Domain, mac, domBandwidth(*req.Bandwidth), active); err != nil {
+			return nil, err
+		}
+	}
+
+	hv.fetchVMSpecs(vm)
+	return getVMNic(vm, mac)
+}
+
+// Plugs a NIC into the domain, live as well when the domain is running. The
+// MAC is generated in the range libvirt uses when none is given.
+func (hv *HV) AttachNic(vm *models.VM, req *util.IfaceRequest) (*models.VMNic, error) {
+	if err := hv.ensureConn(); err != nil {
+		return nil, err
+	}
+
+	unlock, err := hv.LockDomain(vm.ID, "attach_nic")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	nic, err := domNic(*req)
+	if err != nil {
+		return nil, err
+	}
+	if nic.MAC == "" {
+		b := make([]byte, 3)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		nic.MAC = fmt.Sprintf("52:54:00:%02x:%02x:%02x", b[0], b[1], b[2])
+	}
+	if _, err := vmNic(vm, nic.MAC); err == nil {
+		return nil, ErrNicExists
+	}
+
+	active, err := hv.Libvirt.IsVMActive(vm.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	devXml, err := libvirt.DeviceXML("interface", nic.Def())
+	if err != nil {
+		return nil, err
+	}
+	if err := hv.Libvirt.AttachVMDevice(vm.Domain, devXml, active); err != nil {
+		return nil, err
+	}
+
+	// The new tap device needs its rules
+	hv.fetchVMSpecs(vm)
+	hv.refreshFirewall(vm)
+
+	mac, err := vmNic(vm, nic.MAC)
+	if err != nil {
+		return nil, err
+	}
+	return getVMNic(vm, mac)
+}
+
+// Unplugs a NIC, live as well when the domain is running
+func (hv *HV) DetachNic(vm *models.VM, mac string) error {
+	if err := hv.ensureConn(); err != nil {
+		return err
+	}
+
+	unlock, err := hv.LockDomain(vm.ID, "detach_nic")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	mac, err = vmNic(vm, mac)
+	if err != nil {
+		return err
+	}
+
+	vm.Mutex.Lock()
+	nic := vm.Nics[mac]
+	def := libvirt.DomDefInterface{Type: "bridge", Mac: &libvirt.DomDefMac{Address: nic.MAC}}
+	def.Source.Bridge = nic.Bridge
+	def.Model.Type = nic.Model
+	alias := nic.Name
+	vm.Mutex.Unlock()
+
+	active, err := hv.Libvirt.IsVMActive(vm.Domain)
+	if err != nil {
+		return err
+	}
+
+	devXml, err := libvirt.DeviceXML("interface", def)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), detachTimeout)
+	defer cancel()
+	err = hv.Libvirt.DetachVMDevice(ctx, vm.Domain, devXml, alias, active)
+
+	hv.fetchVMSpecs(vm)
+	hv.refreshFirewall(vm)
+	return err
+}
+
+func getVMNic(vm *models.VM, mac string) (*models.VMNic, error) {
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+	nic, ok := vm.Nics[mac]
+	if !ok {
+		return nil, ErrNicNotFound
+	}
+	return &models.VMNic{
+		ID:        nic.ID,
+		Name:      nic.Name,
+		MAC:       nic.MAC,
+		Bridge:    nic.Bridge,
+		Model:     nic.Model,
+		VLAN:      nic.VLAN,
+		Trunk:     nic.Trunk,
+		Queues:    nic.Queues,
+		Bandwidth: nic.Bandwidth,
+		TargetDev: nic.TargetDev,
+		IP:        nic.IP,
+		Created:   nic.Created,
+		Updated:   nic.Updated,
+		Remarks:   nic.Remarks,
+		State:     nic.State,
+	}, nil
+}
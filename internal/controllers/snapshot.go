@@ -0,0 +1,263 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/storage"
+	"github.com/BasedDevelopment/auto/internal/util"
+	"github.com/BasedDevelopment/auto/pkg/models"
+)
+
+var (
+	ErrSnapshotNotFound   = errors.New("snapshot not found")
+	ErrSnapshotExternal   = errors.New("external snapshots can't be reverted or deleted")
+	ErrSnapshotMixedDisks = errors.New("block disks can't be snapshotted along with disks outside of a storage, or with file disks while running")
+)
+
+func (hv *HV) GetSnapshots(vm *models.VM) ([]models.VMSnapshot, error) {
+	if err := hv.ensureConn(); err != nil {
+		return nil, err
+	}
+
+	snaps, err := hv.Libvirt.GetSnapshots(vm.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []models.VMSnapshot{}
+	for _, snap := range snaps {
+		s, err := hv.snapshot(snap)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+func (hv *HV) GetSnapshot(vm *models.VM, name string) (models.VMSnapshot, error) {
+	if err := hv.ensureConn(); err != nil {
+		return models.VMSnapshot{}, err
+	}
+
+	snap, err := hv.lookupSnapshot(vm, name)
+	if err != nil {
+		return models.VMSnapshot{}, err
+	}
+	return hv.snapshot(snap)
+}
+
+func (hv *HV) CreateSnapshot(vm *models.VM, req *util.SnapshotCreateRequest) (models.VMSnapshot, error) {
+	if err := hv.ensureConn(); err != nil {
+		return models.VMSnapshot{}, err
+	}
+
+	unlock, err := hv.LockDomain(vm.ID, "snapshot")
+	if err != nil {
+		return models.VMSnapshot{}, err
+	}
+	defer unlock()
+
+	// libvirt internal snapshots need qcow2, raw block disks have to go
+	// through their backend
+	if !req.External {
+		if disks, block := snapshotDisks(vm); block {
+			return hv.createBackendSnapshot(vm, req, disks)
+		}
+	}
+
+	snap, err := hv.Libvirt.CreateSnapshot(vm.Domain, req.Name, req.Description, req.External, req.Quiesce)
+	if err != nil {
+		return models.VMSnapshot{}, err
+	}
+
+	// External snapshots move the disks onto the new overlays
+	if req.External {
+		hv.fetchVMSpecs(vm)
+	}
+
+	return hv.snapshot(snap)
+}
+
+// Disk of a domain and the backend holding it, if any
+type snapshotDisk struct {
+	path    string
+	backend storage.Backend
+}
+
+// Disks of the domain, and whether any of them is a block volume of a backend
+func snapshotDisks(vm *models.VM) (disks []snapshotDisk, block bool) {
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+
+	for _, s := range vm.Storages {
+		if s.Device != "disk" || s.Path == "" {
+			continue
+		}
+		backend, _ := volumeBackend(s.Path)
+		disks = append(disks, snapshotDisk{path: s.Path, backend: backend})
+		if backend != nil && strings.HasPrefix(s.Path, "/dev/") {
+			block = true
+		}
+	}
+	return disks, block
+}
+
+// Snapshots every disk through its backend, with a running domain paused so
+// the disks all match. These snapshots sit next to the volumes, libvirt knows
+// nothing about them.
+func (hv *HV) createBackendSnapshot(vm *models.VM, req *util.SnapshotCreateRequest, disks []snapshotDisk) (models.VMSnapshot, error) {
+	state, err := hv.GetVMState(vm)
+	if err != nil {
+		return models.VMSnapshot{}, err
+	}
+	active := state.StateStr != "Shutoff"
+
+	// File disks of a running domain are held open by qemu, qemu-img can't
+	// snapshot them
+	for _, d := range disks {
+		if d.backend == nil || (active && !strings.HasPrefix(d.path, "/dev/")) {
+			return models.VMSnapshot{}, ErrSnapshotMixedDisks
+		}
+	}
+
+	if active && req.Quiesce {
+		if err := hv.Libvirt.FreezeVMFS(vm.Domain); err != nil {
+			return models.VMSnapshot{}, fmt.Errorf("failed to freeze guest filesystems: %w", err)
+		}
+		defer hv.Libvirt.ThawVMFS(vm.Domain)
+	}
+	if state.StateStr == "Running" {
+		if err := hv.Libvirt.VMPause(vm.Domain); err != nil {
+			return models.VMSnapshot{}, err
+		}
+		defer hv.Libvirt.VMResume(vm.Domain)
+	}
+
+	for _, d := range disks {
+		if err := d.backend.Snapshot(d.path, req.Name); err != nil {
+			return models.VMSnapshot{}, fmt.Errorf("failed to snapshot %s: %w", d.path, err)
+		}
+	}
+
+	return models.VMSnapshot{
+		Name:        req.Name,
+		Description: req.Description,
+		State:       "disk-snapshot",
+		Created:     time.Now(),
+	}, nil
+}
+
+func (hv *HV) RevertSnapshot(vm *models.VM, name string) error {
+	if err := hv.ensureConn(); err != nil {
+		return err
+	}
+
+	unlock, err := hv.LockDomain(vm.ID, "revert_snapshot")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snap, err := hv.lookupInternalSnapshot(vm, name)
+	if err != nil {
+		return err
+	}
+
+	if err := hv.Libvirt.RevertSnapshot(snap); err != nil {
+		return err
+	}
+
+	hv.refreshVM(vm)
+	return nil
+}
+
+func (hv *HV) DeleteSnapshot(vm *models.VM, name string) error {
+	if err := hv.ensureConn(); err != nil {
+		return err
+	}
+
+	unlock, err := hv.LockDomain(vm.ID, "delete_snapshot")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snap, err := hv.lookupInternalSnapshot(vm, name)
+	if err != nil {
+		return err
+	}
+
+	return hv.Libvirt.DeleteSnapshot(snap)
+}
+
+func (hv *HV) lookupSnapshot(vm *models.VM, name string) (libvirt.Snap, error) {
+	snap, err := hv.Libvirt.GetSnapshot(vm.Domain, name)
+	if libvirt.IsSnapshotNotFound(err) {
+		return snap, ErrSnapshotNotFound
+	}
+	return snap, err
+}
+
+// Snapshot libvirt can revert to and delete, external ones stay until the
+// domain is deleted along with its disks
+func (hv *HV) lookupInternalSnapshot(vm *models.VM, name string) (libvirt.Snap, error) {
+	snap, err := hv.lookupSnapshot(vm, name)
+	if err != nil {
+		return snap, err
+	}
+
+	specs, err := hv.Libvirt.GetSnapshotSpecs(snap)
+	if err != nil {
+		return snap, err
+	}
+	if specs.External() {
+		return snap, ErrSnapshotExternal
+	}
+	return snap, nil
+}
+
+func (hv *HV) snapshot(snap libvirt.Snap) (models.VMSnapshot, error) {
+	specs, err := hv.Libvirt.GetSnapshotSpecs(snap)
+	if err != nil {
+		return models.VMSnapshot{}, err
+	}
+
+	current, err := hv.Libvirt.IsCurrentSnapshot(snap)
+	if err != nil {
+		return models.VMSnapshot{}, err
+	}
+
+	s := models.VMSnapshot{
+		Name:        specs.Name,
+		Description: specs.Description,
+		Parent:      specs.Parent.Name,
+		State:       specs.State,
+		Current:     current,
+		External:    specs.External(),
+		Created:     time.Unix(specs.CreationTime, 0),
+	}
+	return s, nil
+}
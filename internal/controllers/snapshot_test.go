@@ -0,0 +1,71 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/BasedDevelopment/auto/internal/storage"
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/google/uuid"
+)
+
+type nopRunner struct{}
+
+func (nopRunner) Run(string, ...string) ([]byte, error) { return nil, nil }
+
+func TestSnapshotDisks(t *testing.T) {
+	lvm := &storage.LVM{VolumeGroup: "vg0", ThinPool: "thin", Runner: nopRunner{}}
+	backends["ssd"] = lvm
+	defer delete(backends, "ssd")
+
+	vm := func(paths ...string) *models.VM {
+		vm := &models.VM{ID: uuid.New(), Storages: map[string]*models.VMStorage{}}
+		for i, p := range paths {
+			dev := string(rune('a' + i))
+			vm.Storages["vd"+dev] = &models.VMStorage{Device: "disk", Path: p}
+		}
+		// Never snapshotted
+		vm.Storages["sda"] = &models.VMStorage{Device: "cdrom", Path: "/var/lib/auto/images/install.iso"}
+		return vm
+	}
+
+	for _, tc := range []struct {
+		name  string
+		vm    *models.VM
+		disks int
+		owned int
+		block bool
+	}{
+		{"lvm", vm("/dev/vg0/dom-0", "/dev/vg0/dom-1"), 2, 2, true},
+		{"mixed", vm("/dev/vg0/dom-0", "/var/lib/libvirt/images/dom.qcow2"), 2, 1, true},
+		{"files", vm("/var/lib/libvirt/images/dom.qcow2"), 1, 0, false},
+	} {
+		disks, block := snapshotDisks(tc.vm)
+		owned := 0
+		for _, d := range disks {
+			if d.backend != nil {
+				owned++
+			}
+		}
+		if len(disks) != tc.disks || owned != tc.owned || block != tc.block {
+			t.Errorf("%s: got %d disks, %d owned, block %v", tc.name, len(disks), owned, block)
+		}
+	}
+}
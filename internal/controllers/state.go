@@ -0,0 +1,207 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/BasedDevelopment/auto/internal/util"
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// Libvirt states (as named in state_reason.go) each action can be taken from
+var stateTransitions = map[string][]string{
+	"start":    {"Shutoff", "Crashed"},
+	"reboot":   {"Running", "Blocked"},
+	"poweroff": {"Running", "Blocked"},
+	"stop":     {"Running", "Blocked", "Paused", "Shutdown", "Crashed", "PMSuspended"},
+	"reset":    {"Running", "Blocked", "Paused"},
+	"pause":    {"Running", "Blocked"},
+	"resume":   {"Paused"},
+	"suspend":  {"Running"},
+	"wakeup":   {"PMSuspended"},
+	"save":     {"Running", "Blocked", "Paused"},
+	"restore":  {"Shutoff"},
+}
+
+// How a poweroff went, see models.VMStateChange
+const (
+	ShutdownRequested = "requested"
+	ShutdownGraceful  = "graceful"
+	ShutdownForced    = "forced"
+	ShutdownTimeout   = "timeout"
+)
+
+const shutdownPollInterval = 1 * time.Second
+
+// Returned when the domain is not in a state the action can be taken from
+type TransitionError struct {
+	Action string
+	State  string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("can't %s a domain in state %s", e.Action, e.State)
+}
+
+// Checks the action can be taken from the given state
+func checkTransition(action string, state models.VMState) error {
+	allowed, ok := stateTransitions[action]
+	if !ok {
+		return fmt.Errorf("unknown state action: %s", action)
+	}
+	if !util.Contains(allowed, state.StateStr) {
+		return &TransitionError{Action: action, State: state.StateStr}
+	}
+
+	// Starting a domain with a managed save image restores it, restore is
+	// only there to make sure that's what happens
+	if action == "restore" && !state.ManagedSave {
+		return &TransitionError{Action: action, State: state.StateStr + " without managed save"}
+	}
+	return nil
+}
+
+// Takes a state action on a domain after checking it makes sense from the
+// current state. Actions on the same domain are serialized through the
+// domain lock, a concurrent one fails with ErrDomainBusy.
+//
+// A poweroff with a timeout only sends the shutdown request here, waiting
+// for the guest is left to a job which is returned instead and keeps the
+// domain lock until it's done.
+func (hv *HV) SetVMState(vm *models.VM, req *util.SetDomainStateRequest) (res models.VMStateChange, job *models.Job, err error) {
+	action := req.State
+	unlock, err := hv.LockDomain(vm.ID, action)
+	if err != nil {
+		return res, nil, err
+	}
+	handedOff := false
+	defer func() {
+		if !handedOff {
+			unlock()
+		}
+	}()
+
+	state, err := hv.GetVMState(vm)
+	if err != nil {
+		return res, nil, err
+	}
+	res.VMState = state
+
+	if err := checkTransition(action, state); err != nil {
+		return res, nil, err
+	}
+
+	switch action {
+	case "start", "restore":
+		err = hv.Libvirt.VMStart(vm.Domain)
+	case "reboot":
+		err = hv.Libvirt.VMReboot(vm.Domain)
+	case "poweroff":
+		err = hv.Libvirt.VMPowerOff(vm.Domain, req.Method)
+		if err == nil && req.Timeout > 0 {
+			j := startJob(models.Job{Type: action, Domain: vm.ID}, unlock, func(progress JobProgress) (interface{}, error) {
+				return hv.awaitShutdown(vm, req, progress)
+			})
+			handedOff = true
+			return res, &j, nil
+		}
+		res.Shutdown = ShutdownRequested
+	case "stop":
+		err = hv.Libvirt.VMStop(vm.Domain)
+	case "reset":
+		err = hv.Libvirt.VMReset(vm.Domain)
+	case "pause":
+		err = hv.Libvirt.VMPause(vm.Domain)
+	case "resume":
+		err = hv.Libvirt.VMResume(vm.Domain)
+	case "suspend":
+		err = hv.Libvirt.VMPMSuspend(vm.Domain)
+	case "wakeup":
+		err = hv.Libvirt.VMPMWakeup(vm.Domain)
+	case "save":
+		err = hv.Libvirt.VMManagedSave(vm.Domain)
+	}
+	if err != nil {
+		return res, nil, fmt.Errorf("failed to %s domain: %w", action, err)
+	}
+
+	if err := hv.refreshVMState(vm); err != nil {
+		return res, nil, err
+	}
+
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+	res.VMState = vm.State
+	return res, nil, nil
+}
+
+// Waits for a guest already asked to shut down to shut off, destroying it if
+// it hasn't by the deadline and force is set. The result tells which it was.
+func (hv *HV) awaitShutdown(vm *models.VM, req *util.SetDomainStateRequest, progress JobProgress) (res models.VMStateChange, err error) {
+	shutoff := func() (bool, error) {
+		state, err := hv.GetVMState(vm)
+		res.VMState = state
+		return state.StateStr == "Shutoff", err
+	}
+
+	timeout := time.Duration(req.Timeout) * time.Second
+	start := time.Now()
+	for time.Since(start) < timeout {
+		time.Sleep(shutdownPollInterval)
+		off, err := shutoff()
+		if err != nil {
+			return res, err
+		}
+		if off {
+			res.Shutdown = ShutdownGraceful
+			return res, nil
+		}
+		progress(int(time.Since(start) * 100 / timeout))
+	}
+
+	if !req.Force {
+		res.Shutdown = ShutdownTimeout
+		return res, nil
+	}
+
+	log.Info().
+		Str("domain", vm.ID.String()).
+		Int("timeout", req.Timeout).
+		Msg("guest did not shut down in time, destroying it")
+	if err := hv.Libvirt.VMStop(vm.Domain); err != nil {
+		// It may have made it after all since the last check
+		if off, _ := shutoff(); off {
+			res.Shutdown = ShutdownGraceful
+			return res, nil
+		}
+		return res, err
+	}
+	res.Shutdown = ShutdownForced
+
+	if err := hv.refreshVMState(vm); err != nil {
+		return res, err
+	}
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+	res.VMState = vm.State
+	return res, nil
+}
@@ -0,0 +1,76 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/BasedDevelopment/auto/pkg/models"
+)
+
+func TestCheckTransition(t *testing.T) {
+	for _, tc := range []struct {
+		action      string
+		state       string
+		managedSave bool
+		ok          bool
+	}{
+		{"start", "Shutoff", false, true},
+		{"start", "Crashed", false, true},
+		{"start", "Running", false, false},
+		{"reboot", "Running", false, true},
+		{"reboot", "Paused", false, false},
+		{"poweroff", "Blocked", false, true},
+		{"poweroff", "Shutoff", false, false},
+		{"stop", "PMSuspended", false, true},
+		{"stop", "Shutoff", false, false},
+		{"reset", "Paused", false, true},
+		{"pause", "Paused", false, false},
+		{"resume", "Paused", false, true},
+		{"resume", "Running", false, false},
+		{"suspend", "Running", false, true},
+		{"wakeup", "PMSuspended", false, true},
+		{"wakeup", "Running", false, false},
+		{"save", "Paused", false, true},
+		{"save", "Shutoff", false, false},
+		{"restore", "Shutoff", true, true},
+		{"restore", "Shutoff", false, false},
+		{"restore", "Running", true, false},
+	} {
+		err := checkTransition(tc.action, models.VMState{StateStr: tc.state, ManagedSave: tc.managedSave})
+		if tc.ok {
+			if err != nil {
+				t.Errorf("%s from %s: %v", tc.action, tc.state, err)
+			}
+			continue
+		}
+		var te *TransitionError
+		if !errors.As(err, &te) || te.Action != tc.action {
+			t.Errorf("%s from %s: expected a TransitionError, got %v", tc.action, tc.state, err)
+		}
+	}
+
+	// Not a transition problem, the action itself is wrong
+	err := checkTransition("explode", models.VMState{StateStr: "Running"})
+	var te *TransitionError
+	if err == nil || errors.As(err, &te) {
+		t.Errorf("unknown action: got %v", err)
+	}
+}
@@ -1,60 +1,120 @@
 package controllers
 
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/BasedDevelopment/auto/internal/config"
+	"github.com/BasedDevelopment/auto/internal/storage"
+	"github.com/BasedDevelopment/auto/internal/util"
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// Directories of each kind of content within a storage
+const (
+	StorageImages      = "images"
+	StorageCloudImages = "cloud-images"
+	StorageDisks       = "disks"
+)
+
+var ErrStorageNotFound = errors.New("storage not found")
+
 var (
-	CloudImages   = []map[string]string{}
-	Images        = []map[string]string{}
-	Disks         = []map[string]string{}
-	CloudInitPath = []map[string]string{}
+	CloudImages   = []string{}
+	Images        = []string{}
+	Disks         = []string{}
+	CloudInitPath = ""
 )
 
-/*
-func CheckStorage() error {
-	for _, storage := range config.Config.Storage {
-		if !storage.Enabled {
+// Disk backends of the storages with disks, by storage name
+var backends = map[string]storage.Backend{}
+
+// Sets up every enabled storage from the config as a named storage, creating
+// the content directories as needed
+func (hv *HV) CheckStorage() error {
+	storages := make(map[string]*models.HVStorage)
+
+	for name, cfg := range config.Config.Storage {
+		if !cfg.Enabled {
 			continue
 		}
 
-		if _, err := os.Stat(storage.Path); os.IsNotExist(err) {
+		if _, err := os.Stat(cfg.Path); err != nil {
 			return err
 		}
 
-		if storage.CloudImage {
-			path := storage.Path + "/cloud-images"
-			if _, err := os.Stat(path); os.IsNotExist(err) {
-				if err := os.Mkdir(path, 0755); err != nil {
-					return err
-				}
-			}
-			CloudImages = append(CloudImages, path)
+		s := &models.HVStorage{
+			Name:        name,
+			Type:        cfg.Type,
+			Path:        cfg.Path,
+			Images:      cfg.Iso,
+			CloudImages: cfg.CloudImage,
+			Disks:       cfg.Disk,
+			Remarks:     cfg.Remarks,
 		}
 
-		if storage.Iso {
-			path := storage.Path + "/images"
-			if _, err := os.Stat(path); os.IsNotExist(err) {
-				if err := os.Mkdir(path, 0755); err != nil {
-					return err
-				}
+		dirs := []struct {
+			enabled bool
+			dir     string
+			list    *[]string
+		}{
+			{cfg.CloudImage, StorageCloudImages, &CloudImages},
+			{cfg.Iso, StorageImages, &Images},
+			{cfg.Disk, StorageDisks, &Disks},
+		}
+		for _, d := range dirs {
+			if !d.enabled {
+				continue
 			}
-			Images = append(Images, path)
+			path := filepath.Join(cfg.Path, d.dir)
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			*d.list = append(*d.list, path)
 		}
 
-		if storage.Disk {
-			path := storage.Path + "/disks"
-			if _, err := os.Stat(path); os.IsNotExist(err) {
-				if err := os.Mkdir(path, 0755); err != nil {
-					return err
-				}
+		if cfg.Disk {
+			backend, err := storage.New(storage.Config{
+				Type:        cfg.Type,
+				Path:        cfg.Path,
+				VolumeGroup: cfg.VolumeGroup,
+				ThinPool:    cfg.ThinPool,
+				Dataset:     cfg.Dataset,
+			}, storage.ExecRunner{})
+			if err != nil {
+				return fmt.Errorf("storage %s: %v", name, err)
 			}
-			Disks = append(Disks, path)
+			backends[name] = backend
 		}
+
+		storages[name] = s
 	}
 
 	if config.Config.CloudInit.Enabled {
-		if _, err := os.Stat(config.Config.CloudInit.Path); os.IsNotExist(err) {
+		if _, err := os.Stat(config.Config.CloudInit.Path); err != nil {
 			return err
-		} else {
-			CloudInitPath = config.Config.CloudInit.Path
 		}
+		CloudInitPath = config.Config.CloudInit.Path
+	}
+
+	hv.Mutex.Lock()
+	hv.Storages = storages
+	hv.Mutex.Unlock()
+
+	for _, s := range storages {
+		hv.refreshStorage(s)
 	}
 
 	log.Info().
@@ -67,17 +127,345 @@ func CheckStorage() error {
 	return nil
 }
 
-func (hv *HV) ListDir(path string) ([]string, error) {
-	files, err := os.ReadDir(path)
+// Backend for a disk request, the storage is given by name or by its disks
+// directory
+func diskBackend(path string) (storage.Backend, bool) {
+	if backend, ok := backends[path]; ok {
+		return backend, true
+	}
+	for name, backend := range backends {
+		if filepath.Clean(path) == filepath.Join(config.Config.Storage[name].Path, StorageDisks) {
+			return backend, true
+		}
+	}
+	return nil, false
+}
+
+// Backend holding the volume at path
+func volumeBackend(path string) (storage.Backend, bool) {
+	for _, backend := range backends {
+		if backend.Owns(path) {
+			return backend, true
+		}
+	}
+	return nil, false
+}
+
+// All storages with fresh usage, by name
+func (hv *HV) GetStorages() []*models.HVStorage {
+	hv.Mutex.Lock()
+	list := make([]*models.HVStorage, 0, len(hv.Storages))
+	for _, s := range hv.Storages {
+		list = append(list, s)
+	}
+	hv.Mutex.Unlock()
+
+	sort.Slice(list, func(i, k int) bool {
+		return list[i].Name < list[k].Name
+	})
+	for _, s := range list {
+		hv.refreshStorage(s)
+	}
+	return list
+}
+
+func (hv *HV) GetStorage(name string) (*models.HVStorage, error) {
+	hv.Mutex.Lock()
+	s, ok := hv.Storages[name]
+	hv.Mutex.Unlock()
+	if !ok {
+		return nil, ErrStorageNotFound
+	}
+
+	hv.refreshStorage(s)
+	return s, nil
+}
+
+// Whether the storage holds the kind of content
+func hasContent(s *models.HVStorage, content string) bool {
+	switch content {
+	case StorageImages:
+		return s.Images
+	case StorageCloudImages:
+		return s.CloudImages
+	case StorageDisks:
+		return s.Disks
+	}
+	return false
+}
+
+// Files of one kind of content in a storage, disks are listed by domain
+// directory
+func (hv *HV) ListStorage(s *models.HVStorage, content string) ([]models.StorageFile, error) {
+	if !hasContent(s, content) {
+		return nil, ErrStorageNotFound
+	}
+
+	return listStorageFiles(filepath.Join(s.Path, content))
+}
+
+// Usage of lvm and zfs storages is the one of their volume group or dataset.
+// For directories it comes from the libvirt pool when libvirtd is local,
+// statfs otherwise since a pool on a remote libvirtd would describe the wrong
+// host.
+func (hv *HV) refreshStorage(s *models.HVStorage) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if backend, ok := backends[s.Name]; ok && s.Type != "" && s.Type != storage.TypeFS {
+		size, err := backend.Size()
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("storage", s.Name).
+				Msg("Failed to get storage size")
+			return
+		}
+		free, err := backend.Free()
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("storage", s.Name).
+				Msg("Failed to get storage free space")
+			return
+		}
+		s.Size = int(size)
+		s.Free = int(free)
+		s.Used = int(size - free)
+		s.Updated = time.Now()
+		return
+	}
+
+	if hv.Libvirt != nil && hv.Libvirt.IsLocal() && hv.ensureConn() == nil {
+		pool, err := hv.Libvirt.EnsureDirPool(s.Name, s.Path)
+		if err == nil {
+			capacity, allocation, available, err := hv.Libvirt.GetPoolInfo(pool)
+			if err == nil {
+				s.Pool = pool
+				s.ID = pool.UUID()
+				s.Size = int(capacity)
+				s.Used = int(allocation)
+				s.Free = int(available)
+				s.Updated = time.Now()
+				return
+			}
+		}
+		log.Warn().
+			Err(err).
+			Str("storage", s.Name).
+			Msg("Failed to get storage pool info, falling back to statfs")
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(s.Path, &st); err != nil {
+		log.Error().
+			Err(err).
+			Str("storage", s.Name).
+			Msg("Failed to statfs storage")
+		return
+	}
+	bsize := uint64(st.Bsize)
+	s.Size = int(st.Blocks * bsize)
+	s.Free = int(st.Bavail * bsize)
+	s.Used = int((st.Blocks - st.Bfree) * bsize)
+	s.Updated = time.Now()
+}
+
+func listStorageFiles(dir string) ([]models.StorageFile, error) {
+	files := []models.StorageFile{}
+
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(dir, path)
+
+		// Uploads and downloads in progress
+		if strings.HasPrefix(filepath.Base(path), ".") {
+			return nil
+		}
+
+		f := models.StorageFile{
+			Name: name,
+			Path: path,
+			Size: info.Size(),
+		}
+		f.Format, f.VirtualSize, err = imageFormat(path, info.Size())
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		return nil
+	})
+	return files, err
+}
+
+// qcow2 header: magic, version, backing file offset and size, cluster
+// bits, then the virtual size
+var qcow2Magic = []byte{'Q', 'F', 'I', 0xfb}
+
+const qcow2SizeOffset = 24
+
+// Format of an image and the size the guest sees, anything that isn't
+// qcow2 is raw as far as qemu is concerned
+func imageFormat(path string, size int64) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	header := make([]byte, qcow2SizeOffset+8)
+	if _, err := io.ReadFull(f, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return "raw", size, nil
+		}
+		return "", 0, err
+	}
+
+	if string(header[:4]) != string(qcow2Magic) {
+		return "raw", size, nil
+	}
+	return "qcow2", int64(binary.BigEndian.Uint64(header[qcow2SizeOffset:])), nil
+}
+
+// Path of a new image in a storage, content is images or cloud-images
+func imagePath(s *models.HVStorage, content string, name string) (string, error) {
+	if content == StorageDisks || !hasContent(s, content) {
+		return "", ErrStorageNotFound
+	}
+	return filepath.Join(s.Path, content, name), nil
+}
+
+// Streams an uploaded image into the storage, sum is checked when given
+func (hv *HV) UploadImage(s *models.HVStorage, content string, name string, r io.Reader, sum string) (models.StorageFile, error) {
+	path, err := imagePath(s, content, name)
+	if err != nil {
+		return models.StorageFile{}, err
+	}
+
+	unlock, err := hv.LockFile(path, "upload")
+	if err != nil {
+		return models.StorageFile{}, err
+	}
+	defer unlock()
+
+	if err := storage.WriteImage(path, r, sum); err != nil {
+		return models.StorageFile{}, err
+	}
+	return storageFile(path, name)
+}
+
+// Image downloads can take a while, only getting a response is bounded by the
+// client and the download as a whole by fetchTimeout
+var fetchClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+const fetchTimeout = 6 * time.Hour
+
+// Downloads an image into the storage as a job
+func (hv *HV) FetchImage(s *models.HVStorage, content string, req *util.ImageFetchRequest) (models.Job, error) {
+	path, err := imagePath(s, content, req.Name)
 	if err != nil {
-		return nil, err
+		return models.Job{}, err
 	}
 
-	var names []string
-	for _, file := range files {
-		names = append(names, file.Name())
+	// Fail early rather than in the job
+	if _, err := os.Stat(path); err == nil {
+		return models.Job{}, storage.ErrImageExists
 	}
 
-	return names, nil
+	return hv.StartFileJob("fetch_image", path, func(progress JobProgress) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+
+		if err := storage.FetchImage(ctx, fetchClient, req.URL, path, req.SHA256, progress); err != nil {
+			return nil, err
+		}
+		return storageFile(path, req.Name)
+	})
+}
+
+func storageFile(path string, name string) (models.StorageFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return models.StorageFile{}, err
+	}
+
+	f := models.StorageFile{Name: name, Path: path, Size: info.Size()}
+	f.Format, f.VirtualSize, err = imageFormat(path, info.Size())
+	return f, err
+}
+
+var ErrFileNotFound = errors.New("file not found")
+
+// Details of a file in a storage, name is relative to the content directory
+func (hv *HV) InspectStorageFile(s *models.HVStorage, content string, name string) (info models.StorageFileInfo, err error) {
+	if !hasContent(s, content) {
+		return info, ErrStorageNotFound
+	}
+
+	// Names come from the URL, they must not lead out of the directory
+	dir := filepath.Join(s.Path, content)
+	path := filepath.Join(dir, name)
+	if !strings.HasPrefix(path, dir+"/") {
+		return info, ErrFileNotFound
+	}
+	if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+		return info, ErrFileNotFound
+	}
+
+	chain, err := storage.Info(storage.ExecRunner{}, path)
+	if err != nil {
+		return info, err
+	}
+
+	info.Name = name
+	info.ImageInfo = imageInfo(chain[0])
+	info.BackingChain = []models.ImageInfo{}
+	for _, i := range chain[1:] {
+		info.BackingChain = append(info.BackingChain, imageInfo(i))
+	}
+	return info, nil
+}
+
+func imageInfo(i storage.ImageInfo) models.ImageInfo {
+	info := models.ImageInfo{
+		Path:          i.Filename,
+		Format:        i.Format,
+		VirtualSize:   i.VirtualSize,
+		ActualSize:    i.ActualSize,
+		Dirty:         i.DirtyFlag,
+		Corrupt:       i.Corrupt(),
+		BackingFile:   i.FullBackingFilename,
+		BackingFormat: i.BackingFormat,
+		Snapshots:     []models.ImageSnapshot{},
+	}
+	for _, snap := range i.Snapshots {
+		info.Snapshots = append(info.Snapshots, models.ImageSnapshot{
+			ID:          snap.ID,
+			Name:        snap.Name,
+			VMStateSize: snap.VMStateSize,
+			Created:     time.Unix(snap.DateSec, 0),
+		})
+	}
+	return info
 }
-*/
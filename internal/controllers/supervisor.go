@@ -0,0 +1,105 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/BasedDevelopment/eve/pkg/status"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	reconnectMinBackoff = 1 * time.Second
+	reconnectMaxBackoff = 1 * time.Minute
+)
+
+// Watch the libvirt connection and bring it back up whenever it drops
+func (hv *HV) supervise(ctx context.Context) {
+	for {
+		select {
+		case <-hv.Libvirt.Disconnected():
+		case <-ctx.Done():
+			return
+		}
+
+		hv.Mutex.Lock()
+		if hv.Status == status.StatusRunning {
+			log.Warn().Msg("Lost connection to libvirt")
+			hv.setStatus(status.StatusUnknown, "Lost connection to libvirt")
+		}
+		hv.Mutex.Unlock()
+
+		if !hv.reconnect(ctx) {
+			return
+		}
+	}
+}
+
+// Retry resync with exponential backoff until it succeeds, returns false if
+// ctx is done first
+func (hv *HV) reconnect(ctx context.Context) bool {
+	backoff := reconnectMinBackoff
+	for attempt := 1; ; attempt++ {
+		err := hv.resync(ctx)
+		if err == nil {
+			log.Info().
+				Int("attempts", attempt).
+				Msg("Reconnected to libvirt")
+			return true
+		}
+
+		log.Warn().
+			Err(err).
+			Int("attempt", attempt).
+			Dur("retry_in", backoff).
+			Msg("Failed to reconnect to libvirt")
+		hv.Mutex.Lock()
+		if hv.Status != status.StatusRunning {
+			hv.setStatus(status.StatusUnknown, fmt.Sprintf("Reconnecting to libvirt (attempt %d): %v", attempt, err))
+		}
+		hv.Mutex.Unlock()
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return false
+		}
+
+		backoff *= 2
+		if backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+// Connect if needed, then rebuild everything derived from the connection:
+// specs, stats and bridges (done on connect), the VM list and the event
+// subscription, which does not survive a reconnect
+func (hv *HV) resync(ctx context.Context) error {
+	if err := hv.ensureConn(); err != nil {
+		return err
+	}
+	if err := hv.InitVMs(); err != nil {
+		return err
+	}
+	return hv.WatchEvents(ctx)
+}
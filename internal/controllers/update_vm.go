@@ -0,0 +1,132 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/util"
+	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// Applies a partial spec to the persistent config of the domain, and live
+// where libvirt allows it. Whatever could not be applied live is reported
+// back as requiring a reboot.
+func (hv *HV) UpdateDomain(vm *models.VM, req *util.DomainUpdateRequest) (res models.VMUpdateResult, err error) {
+	res.Updated = []string{}
+	res.RebootRequired = []string{}
+
+	if err := hv.ensureConn(); err != nil {
+		return res, err
+	}
+
+	vm.Mutex.Lock()
+	active, err := hv.Libvirt.IsVMActive(vm.Domain)
+	if err == nil {
+		err = hv.updateDomain(vm, req, active, &res)
+	}
+	vm.Mutex.Unlock()
+	if err != nil {
+		return res, err
+	}
+
+	hv.fetchVMSpecs(vm)
+	return res, nil
+}
+
+func (hv *HV) updateDomain(vm *models.VM, req *util.DomainUpdateRequest, active bool, res *models.VMUpdateResult) error {
+	l := hv.Libvirt
+
+	// The persistent config goes in one go so a failure leaves it untouched
+	cfg := libvirt.DomConfigUpdate{
+		Vcpus:       req.CPU,
+		Description: req.Description,
+		Boot:        req.Boot,
+	}
+	if req.Memory != nil {
+		kib := uint64(*req.Memory) * 1024
+		cfg.Memory = &kib
+	}
+	if req.MaxMemory != nil {
+		kib := uint64(*req.MaxMemory) * 1024
+		cfg.MaxMemory = &kib
+	}
+	if req.CPU != nil || req.Memory != nil || req.MaxMemory != nil || req.Description != nil || len(req.Boot) != 0 {
+		if err := l.UpdateVMConfig(vm.Domain, cfg); err != nil {
+			return fmt.Errorf("failed to update domain config: %w", err)
+		}
+	}
+
+	// Tries the live change, the config change is already done by now
+	live := func(field string, apply func() error) {
+		res.Updated = append(res.Updated, field)
+		if !active {
+			return
+		}
+		if err := apply(); err != nil {
+			log.Debug().
+				Err(err).
+				Str("domain", vm.ID.String()).
+				Str("field", field).
+				Msg("live update not possible, reboot required")
+			res.RebootRequired = append(res.RebootRequired, field)
+		}
+	}
+	// Fixed for the lifetime of the qemu process
+	reboot := func(field string) {
+		res.Updated = append(res.Updated, field)
+		if active {
+			res.RebootRequired = append(res.RebootRequired, field)
+		}
+	}
+
+	if req.CPU != nil {
+		live("cpu", func() error {
+			return l.SetVMLiveVcpus(vm.Domain, *req.CPU)
+		})
+	}
+	if cfg.Memory != nil {
+		live("memory", func() error {
+			return l.SetVMLiveMemory(vm.Domain, *cfg.Memory)
+		})
+	}
+	if req.MaxMemory != nil {
+		reboot("max_memory")
+	}
+	if req.Description != nil {
+		live("description", func() error {
+			return l.SetVMLiveDescription(vm.Domain, *req.Description)
+		})
+	}
+
+	if req.Autostart != nil {
+		if err := l.SetVMAutostart(vm.Domain, *req.Autostart); err != nil {
+			return fmt.Errorf("failed to set autostart: %v", err)
+		}
+		res.Updated = append(res.Updated, "autostart")
+	}
+
+	if len(req.Boot) != 0 {
+		reboot("boot")
+	}
+
+	return nil
+}
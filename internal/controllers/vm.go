@@ -19,9 +19,13 @@
 package controllers
 
 import (
+	"sort"
 	"strconv"
+	"time"
 
+	"github.com/BasedDevelopment/auto/internal/libvirt"
 	"github.com/BasedDevelopment/auto/pkg/models"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 )
 
@@ -41,18 +45,63 @@ func (hv *HV) InitVMs() error {
 	hv.Mutex.Lock()
 	defer hv.Mutex.Unlock()
 
-	// Marshall the HV.VMs struct in
+	// Marshall the HV.VMs struct in, keeping the VMs we already know about
 	for id, dom := range doms {
-		hv.VMs[id] = &models.VM{
-			Domain: dom,
-			ID:     id,
+		vm, ok := hv.VMs[id]
+		if !ok {
+			vm = &models.VM{ID: id}
+			hv.VMs[id] = vm
+		}
+		vm.Domain = dom
+		go hv.refreshVM(vm)
+	}
+
+	// Drop the ones libvirt no longer knows about
+	for id := range hv.VMs {
+		if _, ok := doms[id]; !ok {
+			delete(hv.VMs, id)
 		}
-		go hv.fetchVMSpecs(hv.VMs[id])
 	}
 
 	return nil
 }
 
+// VM by ID, HV.VMs is written by the event goroutine so it's only read
+// under the lock
+func (hv *HV) LookupVM(id uuid.UUID) (*models.VM, bool) {
+	hv.Mutex.Lock()
+	defer hv.Mutex.Unlock()
+	vm, ok := hv.VMs[id]
+	return vm, ok
+}
+
+// Copy of the known VMs, sorted by ID
+func (hv *HV) ListVMs() []*models.VM {
+	hv.Mutex.Lock()
+	list := make([]*models.VM, 0, len(hv.VMs))
+	for _, vm := range hv.VMs {
+		list = append(list, vm)
+	}
+	hv.Mutex.Unlock()
+
+	sort.Slice(list, func(i, k int) bool {
+		return list[i].ID.String() < list[k].ID.String()
+	})
+	return list
+}
+
+// Refetch the specs and state of a VM, and the firewall of its NICs
+func (hv *HV) refreshVM(vm *models.VM) {
+	hv.fetchVMSpecs(vm)
+	if err := hv.refreshVMState(vm); err != nil {
+		log.Error().
+			Err(err).
+			Str("domain", vm.ID.String()).
+			Msg("Failed to get VM state")
+	}
+	hv.refreshFirewall(vm)
+}
+
 func (hv *HV) fetchVMSpecs(vm *models.VM) {
 	if err := hv.ensureConn(); err != nil {
 		log.Error().Err(err).Msg("Failed to ensure connection")
@@ -61,6 +110,7 @@ func (hv *HV) fetchVMSpecs(vm *models.VM) {
 	specs, err := hv.Libvirt.GetVMSpecs(vm.Domain)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get VM specs")
+		return
 	}
 
 	vm.Mutex.Lock()
@@ -90,14 +140,146 @@ func (hv *HV) fetchVMSpecs(vm *models.VM) {
 
 	vm.Memory = mem
 
+	now := time.Now()
+
 	// USBs
-	//TODO
+	vm.USBs = []models.VMUSB{}
+	for _, dev := range specs.Devices.Hostdev {
+		if dev.Type != "usb" {
+			continue
+		}
+		vm.USBs = append(vm.USBs, models.VMUSB{
+			Vendor:  dev.Source.Vendor.ID,
+			Product: dev.Source.Product.ID,
+			Bus:     dev.Source.Address.Bus,
+			Device:  dev.Source.Address.Device,
+		})
+	}
+
 	// Disks
-	//TODO
+	storages := make(map[string]*models.VMStorage)
+	for _, disk := range specs.Devices.Disk {
+		if disk.Target.Dev == "" {
+			continue
+		}
+		path := disk.Source.File
+		if disk.Type == "block" {
+			path = disk.Source.Dev
+		}
+		storage := &models.VMStorage{
+			// Stable for as long as the disk sits on the same target
+			ID:        uuid.NewSHA1(vm.ID, []byte(disk.Target.Dev)),
+			Path:      path,
+			Device:    disk.Device,
+			TargetDev: disk.Target.Dev,
+			Bus:       disk.Target.Bus,
+			Format:    disk.Driver.Type,
+			Updated:   now,
+		}
+		if old, ok := vm.Storages[disk.Target.Dev]; ok && old.Path == storage.Path {
+			storage.Created = old.Created
+			storage.Remarks = old.Remarks
+		} else {
+			storage.Created = now
+		}
+		// Empty cdrom drives have nothing to report
+		if storage.Path != "" {
+			allocation, capacity, _, err := hv.Libvirt.GetVMBlockInfo(vm.Domain, disk.Target.Dev)
+			if err != nil {
+				log.Debug().
+					Err(err).
+					Str("domain", vm.ID.String()).
+					Str("disk", disk.Target.Dev).
+					Msg("Failed to get block info")
+			} else {
+				storage.Size = int(capacity)
+				storage.Allocation = int(allocation)
+			}
+		}
+		storages[disk.Target.Dev] = storage
+	}
+	vm.Storages = storages
+
 	// Nics
-	//TODO
+	nics := make(map[string]*models.VMNic)
+	for _, iface := range specs.Devices.Interface {
+		mac := iface.Mac.Address
+		if mac == "" {
+			continue
+		}
+		nic := &models.VMNic{
+			ID:        uuid.NewSHA1(vm.ID, []byte(mac)),
+			Name:      iface.Alias.Name,
+			MAC:       mac,
+			Bridge:    iface.Source.Bridge,
+			Model:     iface.Model.Type,
+			TargetDev: iface.Target.Dev,
+			State:     iface.Link.State,
+			Updated:   now,
+		}
+		// Links are up unless libvirt says otherwise
+		if nic.State == "" {
+			nic.State = "up"
+		}
+		// The untagged tag of a trunk is its native VLAN
+		for _, tag := range iface.Vlan.Tag {
+			if iface.Vlan.Trunk == "yes" && tag.NativeMode == "" {
+				nic.Trunk = append(nic.Trunk, tag.ID)
+			} else {
+				nic.VLAN = tag.ID
+			}
+		}
+		nic.Queues = iface.Driver.Queues
+		if nic.Queues == 0 {
+			nic.Queues = 1
+		}
+		nic.Bandwidth = models.VMBandwidth{
+			Inbound:  vmBandwidthLimit(iface.Bandwidth.Inbound),
+			Outbound: vmBandwidthLimit(iface.Bandwidth.Outbound),
+		}
+		if old, ok := vm.Nics[mac]; ok {
+			nic.IP = old.IP
+			nic.Created = old.Created
+			nic.Remarks = old.Remarks
+		} else {
+			nic.Created = now
+		}
+		nics[mac] = nic
+	}
+	vm.Nics = nics
+
 	// Graphics
-	//TODO
+	vm.Graphics = []models.VMGraphics{}
+	for _, g := range specs.Devices.Graphics {
+		listen := g.Listen.Address
+		if listen == "" {
+			listen = g.AttrListen
+		}
+		vm.Graphics = append(vm.Graphics, models.VMGraphics{
+			Type:      g.Type,
+			Port:      g.Port,
+			Websocket: g.Websocket,
+			Listen:    listen,
+		})
+	}
+
+	// Serial console
+	vm.Serial = models.VMSerial{}
+	if c, ok := specs.SerialConsole(); ok {
+		vm.Serial = models.VMSerial{
+			Available: true,
+			Type:      c.Type,
+			Writable:  hv.Libvirt.SerialWritable(c),
+		}
+	}
+}
+
+func vmBandwidthLimit(spec libvirt.BandwidthSpec) models.VMBandwidthLimit {
+	return models.VMBandwidthLimit{
+		Average: int(spec.Average),
+		Peak:    int(spec.Peak),
+		Burst:   int(spec.Burst),
+	}
 }
 
 func (hv *HV) GetVMState(vm *models.VM) (models.VMState, error) {
@@ -110,13 +292,34 @@ func (hv *HV) GetVMState(vm *models.VM) (models.VMState, error) {
 		return models.VMState{}, err
 	}
 
+	managedSave, err := hv.Libvirt.VMHasManagedSave(vm.Domain)
+	if err != nil {
+		return models.VMState{}, err
+	}
+
 	return models.VMState{
 		State:       stateInt,
 		StateStr:    stateStr,
 		StateReason: reasonStr,
+		ManagedSave: managedSave,
+		Updated:     time.Now(),
 	}, nil
 }
 
+// Query the state from libvirt and keep it on the VM
+func (hv *HV) refreshVMState(vm *models.VM) error {
+	state, err := hv.GetVMState(vm)
+	if err != nil {
+		return err
+	}
+
+	vm.Mutex.Lock()
+	defer vm.Mutex.Unlock()
+	vm.State = state
+
+	return nil
+}
+
 func (hv *HV) GetVMConsole(vm *models.VM) (string, error) {
 	if err := hv.ensureConn(); err != nil {
 		return "", err
@@ -0,0 +1,362 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package firewall renders the nftables ruleset of a domain and applies it.
+// Every domain gets its own bridge table holding anti-spoofing and the
+// customer rules of each of its NICs, replaced as a whole in one transaction.
+package firewall
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	Ingress = "ingress"
+	Egress  = "egress"
+
+	Accept = "accept"
+	Drop   = "drop"
+)
+
+// Interface names as the kernel allows them
+var tapRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,15}$`)
+
+// Tap device of a domain NIC and the source addresses it may use. NICs
+// without addresses are only held to their MAC.
+type NIC struct {
+	Tap       string
+	MAC       string
+	Addresses []string
+}
+
+// Customer rule, CIDR is the remote end and Port the destination port, a
+// single one or a range like 8000-8100
+type Rule struct {
+	Direction string
+	Action    string
+	Protocol  string
+	Port      string
+	CIDR      string
+}
+
+type Ruleset struct {
+	ID            uuid.UUID
+	NICs          []NIC
+	IngressPolicy string
+	EgressPolicy  string
+	Rules         []Rule
+}
+
+// Runs nft, replaced in tests
+type Runner interface {
+	Run(name string, args ...string) ([]byte, error)
+}
+
+type ExecRunner struct{}
+
+func (ExecRunner) Run(name string, args ...string) ([]byte, error) {
+	log.Debug().
+		Str("command", name).
+		Strs("args", args).
+		Msg("firewall command")
+
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// Name of the table of a domain, nft identifiers can't have dashes
+func Table(id uuid.UUID) string {
+	return "auto_" + strings.ReplaceAll(id.String(), "-", "")
+}
+
+// Keeps the rendered rulesets in Dir and loads them with nft
+type Firewall struct {
+	Dir    string
+	Runner Runner
+}
+
+func (f *Firewall) path(id uuid.UUID) string {
+	return filepath.Join(f.Dir, id.String()+".nft")
+}
+
+// Replaces the table of the domain with rs
+func (f *Firewall) Apply(rs Ruleset) error {
+	text, err := Render(rs)
+	if err != nil {
+		return err
+	}
+	return f.load(rs.ID, text)
+}
+
+// Drops the table of the domain, it's fine if there is none
+func (f *Firewall) Remove(id uuid.UUID) error {
+	if err := f.load(id, flush(id)); err != nil {
+		return err
+	}
+	return os.Remove(f.path(id))
+}
+
+func (f *Firewall) load(id uuid.UUID, text string) error {
+	path := f.path(id)
+	if err := os.WriteFile(path, []byte(text), 0600); err != nil {
+		return err
+	}
+	_, err := f.Runner.Run("nft", "-f", path)
+	return err
+}
+
+// Declaring the table first makes deleting it work whether it exists or not
+func flush(id uuid.UUID) string {
+	table := Table(id)
+	return "table bridge " + table + " {}\ndelete table bridge " + table + "\n"
+}
+
+// Renders the ruleset as an nft script replacing the table of the domain
+func Render(rs Ruleset) (string, error) {
+	for _, p := range []string{rs.IngressPolicy, rs.EgressPolicy} {
+		if p != "" && p != Accept && p != Drop {
+			return "", fmt.Errorf("invalid policy %q", p)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(flush(rs.ID))
+	b.WriteString("table bridge " + Table(rs.ID) + " {\n")
+
+	b.WriteString("\tchain forward {\n")
+	b.WriteString("\t\ttype filter hook forward priority 0; policy accept;\n")
+	for i, nic := range rs.NICs {
+		if !tapRe.MatchString(nic.Tap) {
+			return "", fmt.Errorf("invalid tap device %q", nic.Tap)
+		}
+		fmt.Fprintf(&b, "\t\tiifname %q jump egress_%d\n", nic.Tap, i)
+		fmt.Fprintf(&b, "\t\toifname %q jump ingress_%d\n", nic.Tap, i)
+	}
+	b.WriteString("\t}\n")
+
+	for i, nic := range rs.NICs {
+		egress, err := egressChain(nic)
+		if err != nil {
+			return "", err
+		}
+		ingress := []string{
+			"ct state established,related accept",
+			"ether type arp accept",
+			"udp sport 67 udp dport 68 accept",
+			"icmpv6 type { nd-router-advert, nd-neighbor-solicit, nd-neighbor-advert } accept",
+		}
+		for _, r := range rs.Rules {
+			line, err := renderRule(r)
+			if err != nil {
+				return "", err
+			}
+			switch r.Direction {
+			case Egress:
+				egress = append(egress, line)
+			case Ingress:
+				ingress = append(ingress, line)
+			}
+		}
+		if rs.EgressPolicy == Drop {
+			egress = append(egress, "drop")
+		}
+		if rs.IngressPolicy == Drop {
+			ingress = append(ingress, "drop")
+		}
+
+		writeChain(&b, "egress_"+strconv.Itoa(i), egress)
+		writeChain(&b, "ingress_"+strconv.Itoa(i), ingress)
+	}
+
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+func writeChain(b *strings.Builder, name string, rules []string) {
+	b.WriteString("\tchain " + name + " {\n")
+	for _, r := range rules {
+		b.WriteString("\t\t" + r + "\n")
+	}
+	b.WriteString("\t}\n")
+}
+
+// Anti-spoofing and rogue DHCP/router advertisements for traffic leaving the
+// NIC, then what it always needs to get on the network
+func egressChain(nic NIC) ([]string, error) {
+	mac, err := net.ParseMAC(nic.MAC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAC %q", nic.MAC)
+	}
+
+	rules := []string{
+		"ether saddr != " + mac.String() + " drop",
+		"arp saddr ether != " + mac.String() + " drop",
+		// Guests don't get to act as DHCP servers or routers, whatever
+		// their own rules say
+		"udp sport 67 udp dport 68 drop",
+		"icmpv6 type { nd-router-advert, nd-redirect } drop",
+		// DHCP discovery happens before the guest has an address
+		"ip saddr 0.0.0.0 udp sport 68 udp dport 67 accept",
+	}
+
+	if len(nic.Addresses) > 0 {
+		var v4, v6 []string
+		for _, a := range nic.Addresses {
+			prefix, err := parsePrefix(a)
+			if err != nil {
+				return nil, err
+			}
+			if prefix.Addr().Is4() {
+				v4 = append(v4, format(prefix))
+			} else {
+				v6 = append(v6, format(prefix))
+			}
+		}
+		if len(v4) > 0 {
+			rules = append(rules,
+				"arp saddr ip != { "+strings.Join(v4, ", ")+" } drop",
+				"ip saddr != { "+strings.Join(v4, ", ")+" } drop",
+			)
+		} else {
+			rules = append(rules, "ether type { arp, ip } drop")
+		}
+		// Link-local addresses are needed for neighbor discovery
+		v6 = append([]string{"fe80::/10"}, v6...)
+		rules = append(rules, "ip6 saddr != { "+strings.Join(v6, ", ")+" } drop")
+	}
+
+	return append(rules,
+		"ct state established,related accept",
+		"ether type arp accept",
+		"udp sport 68 udp dport 67 accept",
+		"icmpv6 type { nd-router-solicit, nd-neighbor-solicit, nd-neighbor-advert } accept",
+	), nil
+}
+
+// Addresses are taken as single hosts, prefixes as they are
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address %q", s)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Single hosts without their prefix length
+func format(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
+
+func renderRule(r Rule) (string, error) {
+	if r.Direction != Ingress && r.Direction != Egress {
+		return "", fmt.Errorf("invalid direction %q", r.Direction)
+	}
+	if r.Action != Accept && r.Action != Drop {
+		return "", fmt.Errorf("invalid action %q", r.Action)
+	}
+
+	var parts []string
+
+	// The remote end is the source of ingress traffic and the destination
+	// of egress traffic
+	if r.CIDR != "" {
+		prefix, err := parsePrefix(r.CIDR)
+		if err != nil {
+			return "", err
+		}
+		family, dir := "ip", "daddr"
+		if prefix.Addr().Is6() {
+			family = "ip6"
+		}
+		if r.Direction == Ingress {
+			dir = "saddr"
+		}
+		parts = append(parts, family+" "+dir+" "+format(prefix))
+	}
+
+	switch r.Protocol {
+	case "", "any":
+		if r.Port != "" {
+			return "", fmt.Errorf("port needs tcp or udp")
+		}
+	case "tcp", "udp":
+		if r.Port == "" {
+			parts = append(parts, "meta l4proto "+r.Protocol)
+			break
+		}
+		port, err := parsePorts(r.Port)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, r.Protocol+" dport "+port)
+	case "icmp", "icmpv6":
+		if r.Port != "" {
+			return "", fmt.Errorf("port needs tcp or udp")
+		}
+		proto := r.Protocol
+		if proto == "icmpv6" {
+			proto = "ipv6-icmp"
+		}
+		parts = append(parts, "meta l4proto "+proto)
+	default:
+		return "", fmt.Errorf("invalid protocol %q", r.Protocol)
+	}
+
+	return strings.Join(append(parts, r.Action), " "), nil
+}
+
+// A port or a range of them, checked since it ends up in the script as is
+func parsePorts(s string) (string, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	from, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil || from == 0 {
+		return "", fmt.Errorf("invalid port %q", s)
+	}
+	if !isRange {
+		return strconv.FormatUint(from, 10), nil
+	}
+	to, err := strconv.ParseUint(hi, 10, 16)
+	if err != nil || to < from {
+		return "", fmt.Errorf("invalid port range %q", s)
+	}
+	return strconv.FormatUint(from, 10) + "-" + strconv.FormatUint(to, 10), nil
+}
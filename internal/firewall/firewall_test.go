@@ -0,0 +1,136 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package firewall_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BasedDevelopment/auto/internal/firewall"
+	"github.com/BasedDevelopment/auto/internal/testutil"
+	"github.com/google/uuid"
+)
+
+var domID = uuid.MustParse("6c3c1b2e-4a1f-4e0a-9d0e-1f6f3b9d2a10")
+
+func TestRender(t *testing.T) {
+	text, err := firewall.Render(firewall.Ruleset{
+		ID: domID,
+		NICs: []firewall.NIC{
+			{Tap: "vnet0", MAC: "52:54:00:12:34:56", Addresses: []string{"203.0.113.10", "2001:db8::10/128"}},
+			{Tap: "vnet1", MAC: "52:54:00:AB:CD:EF"},
+		},
+		IngressPolicy: firewall.Drop,
+		EgressPolicy:  firewall.Accept,
+		Rules: []firewall.Rule{
+			{Direction: firewall.Ingress, Action: firewall.Accept, Protocol: "tcp", Port: "22", CIDR: "198.51.100.0/24"},
+			{Direction: firewall.Ingress, Action: firewall.Accept, Protocol: "tcp", Port: "80-443"},
+			{Direction: firewall.Ingress, Action: firewall.Accept, Protocol: "icmpv6"},
+			{Direction: firewall.Egress, Action: firewall.Drop, Protocol: "tcp", Port: "25"},
+			{Direction: firewall.Egress, Action: firewall.Drop, CIDR: "2001:db8:bad::/48"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Golden(t, "ruleset.nft", text)
+}
+
+func TestRenderInvalid(t *testing.T) {
+	nic := firewall.NIC{Tap: "vnet0", MAC: "52:54:00:12:34:56"}
+	tests := map[string]firewall.Ruleset{
+		"tap":       {NICs: []firewall.NIC{{Tap: `vnet0" accept`, MAC: nic.MAC}}},
+		"mac":       {NICs: []firewall.NIC{{Tap: "vnet0", MAC: "nope"}}},
+		"address":   {NICs: []firewall.NIC{{Tap: "vnet0", MAC: nic.MAC, Addresses: []string{"10.0.0.300"}}}},
+		"policy":    {NICs: []firewall.NIC{nic}, IngressPolicy: "reject"},
+		"direction": {NICs: []firewall.NIC{nic}, Rules: []firewall.Rule{{Direction: "both", Action: firewall.Drop}}},
+		"protocol":  {NICs: []firewall.NIC{nic}, Rules: []firewall.Rule{{Direction: firewall.Egress, Action: firewall.Drop, Protocol: "sctp"}}},
+		"port":      {NICs: []firewall.NIC{nic}, Rules: []firewall.Rule{{Direction: firewall.Egress, Action: firewall.Drop, Protocol: "tcp", Port: "70000"}}},
+		"range":     {NICs: []firewall.NIC{nic}, Rules: []firewall.Rule{{Direction: firewall.Egress, Action: firewall.Drop, Protocol: "udp", Port: "90-80"}}},
+		"icmp port": {NICs: []firewall.NIC{nic}, Rules: []firewall.Rule{{Direction: firewall.Egress, Action: firewall.Drop, Protocol: "icmp", Port: "1"}}},
+		"cidr":      {NICs: []firewall.NIC{nic}, Rules: []firewall.Rule{{Direction: firewall.Egress, Action: firewall.Drop, CIDR: "10.0.0.0/33"}}},
+	}
+	for name, rs := range tests {
+		if _, err := firewall.Render(rs); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+type fakeRunner struct {
+	cmds  []string
+	files []string
+	fail  bool
+}
+
+// Records the command along with the script it loads
+func (r *fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	r.cmds = append(r.cmds, strings.Join(append([]string{name}, args...), " "))
+	script, err := os.ReadFile(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+	r.files = append(r.files, string(script))
+	if r.fail {
+		return nil, errors.New("failed")
+	}
+	return nil, nil
+}
+
+func TestApplyRemove(t *testing.T) {
+	dir := t.TempDir()
+	r := &fakeRunner{}
+	f := &firewall.Firewall{Dir: dir, Runner: r}
+	path := filepath.Join(dir, domID.String()+".nft")
+
+	rs := firewall.Ruleset{ID: domID, NICs: []firewall.NIC{{Tap: "vnet0", MAC: "52:54:00:12:34:56"}}}
+	if err := f.Apply(rs); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Remove(domID); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"nft -f " + path, "nft -f " + path}
+	if !reflect.DeepEqual(r.cmds, want) {
+		t.Errorf("commands mismatch\n--- got ---\n%s\n--- want ---\n%s", strings.Join(r.cmds, "\n"), strings.Join(want, "\n"))
+	}
+	table := firewall.Table(domID)
+	if !strings.HasPrefix(r.files[0], "table bridge "+table+" {}\ndelete table bridge "+table+"\n") {
+		t.Errorf("ruleset doesn't replace the table:\n%s", r.files[0])
+	}
+	if r.files[1] != "table bridge "+table+" {}\ndelete table bridge "+table+"\n" {
+		t.Errorf("unexpected removal script:\n%s", r.files[1])
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("ruleset file left behind")
+	}
+}
+
+func TestApplyFailure(t *testing.T) {
+	f := &firewall.Firewall{Dir: t.TempDir(), Runner: &fakeRunner{fail: true}}
+	rs := firewall.Ruleset{ID: domID, NICs: []firewall.NIC{{Tap: "vnet0", MAC: "52:54:00:12:34:56"}}}
+	if err := f.Apply(rs); err == nil {
+		t.Error("expected nft failure to be returned")
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/digitalocean/go-libvirt"
+)
+
+var (
+	ErrNoSerial       = errors.New("domain has no serial console")
+	ErrSerialReadOnly = errors.New("serial console input is not available")
+)
+
+// The console device of a domain, Pty is the host side of pty consoles
+// and only set while the domain runs
+type SerialConsole struct {
+	Type string
+	Pty  string
+}
+
+func (specs DomSpecs) SerialConsole() (c SerialConsole, ok bool) {
+	console := specs.Devices.Console
+	if console.Type == "" {
+		return c, false
+	}
+
+	c.Type = console.Type
+	c.Pty = console.Source.Path
+	if c.Pty == "" {
+		c.Pty = console.Tty
+	}
+	return c, true
+}
+
+func (l Libvirt) GetVMSerial(dom Dom) (c SerialConsole, err error) {
+	specs, err := l.GetVMSpecs(dom)
+	if err != nil {
+		return
+	}
+
+	c, ok := specs.SerialConsole()
+	if !ok {
+		return c, ErrNoSerial
+	}
+	return c, nil
+}
+
+// Whether input can be sent to the console, see OpenVMSerialInput
+func (l Libvirt) SerialWritable(c SerialConsole) bool {
+	return l.local && c.Type == "pty" && c.Pty != ""
+}
+
+// Copies the console output of a running domain to out until the console
+// closes or writing to out fails. An existing console session is taken over.
+//
+// go-libvirt only carries the guest to client direction of console streams,
+// so libvirt won't see the session end before the next write to out fails.
+// Closing the connection is the only way to end it early.
+func (l Libvirt) StreamVMSerial(dom Dom, out io.Writer) error {
+	return l.conn.DomainOpenConsole(dom.Dom, libvirt.OptString{}, out, uint32(libvirt.DomainConsoleForce))
+}
+
+// Console input is written to the pty directly, which only works when
+// libvirtd runs on this host. go-libvirt only carries output over console
+// streams, so remote consoles are read-only.
+func (l Libvirt) OpenVMSerialInput(c SerialConsole) (io.WriteCloser, error) {
+	if !l.SerialWritable(c) {
+		return nil, ErrSerialReadOnly
+	}
+	return os.OpenFile(c.Pty, os.O_WRONLY|syscall.O_NOCTTY, 0)
+}
@@ -0,0 +1,101 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/digitalocean/go-libvirt"
+)
+
+var ErrDetachTimeout = errors.New("guest did not release the device in time")
+
+// Renders a single device definition, name is its element
+func DeviceXML(name string, def interface{}) (string, error) {
+	var b strings.Builder
+	enc := xml.NewEncoder(&b)
+	enc.Indent("", "  ")
+	if err := enc.EncodeElement(def, xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+		return "", fmt.Errorf("failed to marshal %s definition: %v", name, err)
+	}
+	return b.String(), nil
+}
+
+// Adds a device to the persistent config, and to the running domain when
+// live
+func (l Libvirt) AttachVMDevice(dom Dom, devXml string, live bool) error {
+	flags := libvirt.DomainDeviceModifyConfig
+	if live {
+		flags |= libvirt.DomainDeviceModifyLive
+	}
+	return l.conn.DomainAttachDeviceFlags(dom.Dom, devXml, uint32(flags))
+}
+
+// Removes a device from the running domain when live, then from the
+// persistent config. The guest has to let go of the device first, so the
+// live detach waits for libvirt to report the alias removed until ctx is
+// done. The config is only changed once that happened, a device the guest
+// holds on to stays defined.
+func (l Libvirt) DetachVMDevice(ctx context.Context, dom Dom, devXml string, alias string, live bool) error {
+	if live {
+		if err := l.detachLive(ctx, dom, devXml, alias); err != nil {
+			return err
+		}
+	}
+	return l.conn.DomainDetachDeviceFlags(dom.Dom, devXml, uint32(libvirt.DomainDeviceModifyConfig))
+}
+
+func (l Libvirt) detachLive(ctx context.Context, dom Dom, devXml string, alias string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	events, err := l.conn.SubscribeEvents(ctx, libvirt.DomainEventIDDeviceRemoved, libvirt.OptDomain{})
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to register for device events: %v", err)
+	}
+	// The subscription only shuts down once its channel is drained
+	defer func() {
+		cancel()
+		for range events {
+		}
+	}()
+
+	if err := l.conn.DomainDetachDeviceFlags(dom.Dom, devXml, uint32(libvirt.DomainDeviceModifyLive)); err != nil {
+		return err
+	}
+
+	id := domUUID(dom.Dom)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return errors.New("device event stream closed")
+			}
+			msg, ok := ev.(*libvirt.DomainEventCallbackDeviceRemovedMsg)
+			if ok && domUUID(msg.Msg.Dom) == id && msg.Msg.DevAlias == alias {
+				return nil
+			}
+		case <-ctx.Done():
+			return ErrDetachTimeout
+		}
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/google/uuid"
+)
+
+// Lifecycle event of a domain
+// https://libvirt.org/html/libvirt-libvirt-domain.html#virDomainEventType
+type DomEvent struct {
+	ID     uuid.UUID
+	Dom    Dom
+	Event  libvirt.DomainEventType
+	Detail int32
+}
+
+func (e DomEvent) String() string {
+	switch e.Event {
+	case libvirt.DomainEventDefined:
+		return "Defined"
+	case libvirt.DomainEventUndefined:
+		return "Undefined"
+	case libvirt.DomainEventStarted:
+		return "Started"
+	case libvirt.DomainEventSuspended:
+		return "Suspended"
+	case libvirt.DomainEventResumed:
+		return "Resumed"
+	case libvirt.DomainEventStopped:
+		return "Stopped"
+	case libvirt.DomainEventShutdown:
+		return "Shutdown"
+	case libvirt.DomainEventPmsuspended:
+		return "PMSuspended"
+	case libvirt.DomainEventCrashed:
+		return "Crashed"
+	}
+	return "Unknown"
+}
+
+func (e DomEvent) IsDefined() bool {
+	return e.Event == libvirt.DomainEventDefined
+}
+
+func (e DomEvent) IsUndefined() bool {
+	return e.Event == libvirt.DomainEventUndefined
+}
+
+func (e DomEvent) IsStarted() bool {
+	return e.Event == libvirt.DomainEventStarted
+}
+
+func (e DomEvent) IsStopped() bool {
+	return e.Event == libvirt.DomainEventStopped
+}
+
+// Streams the lifecycle events of every domain until ctx is cancelled, the
+// channel is closed when the connection to libvirt is lost
+func (l Libvirt) LifecycleEvents(ctx context.Context) (<-chan DomEvent, error) {
+	events, err := l.conn.LifecycleEvents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register for lifecycle events: %v", err)
+	}
+
+	ch := make(chan DomEvent)
+	go func() {
+		defer close(ch)
+		for ev := range events {
+			select {
+			case ch <- DomEvent{
+				ID:     domUUID(ev.Dom),
+				Dom:    Dom{ev.Dom},
+				Event:  libvirt.DomainEventType(ev.Event),
+				Detail: ev.Detail,
+			}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
@@ -19,39 +19,54 @@
 package libvirt
 
 import (
+	"crypto/tls"
 	"encoding/xml"
 	"fmt"
-	"net"
-	"strconv"
 	"strings"
-	"time"
 
 	"github.com/BasedDevelopment/auto/internal/util"
 	"github.com/digitalocean/go-libvirt"
-	"github.com/digitalocean/go-libvirt/socket/dialers"
 )
 
 type Libvirt struct {
-	conn *libvirt.Libvirt
+	conn   *libvirt.Libvirt
+	driver libvirt.ConnectURI
+	// libvirtd runs on this host, so its files are ours as well
+	local bool
 }
 
-// Initializes a Libvirt object for later connections
-func Init(ip net.IP, port int) *Libvirt {
-	conn := libvirt.NewWithDialer(dialers.NewRemote(
-		ip.String(),
-		dialers.UsePort(strconv.Itoa(port)),
-		dialers.WithRemoteTimeout(time.Second*2),
-	))
+// Initializes a Libvirt object for later connections, the dialer is picked
+// from the URI transport
+func Init(uri string, tlsConfig *tls.Config) (*Libvirt, error) {
+	c, err := ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer, err := c.dialer(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
 
-	return &Libvirt{conn}
+	return &Libvirt{libvirt.NewWithDialer(dialer), c.Driver, c.Transport == TransportUnix}, nil
+}
+
+func (l Libvirt) IsLocal() bool {
+	return l.local
 }
 
 func (l Libvirt) IsConnected() bool {
 	return l.conn.IsConnected()
 }
 
+// Closed when the connection to libvirt is lost, a new channel is made on
+// every successful Connect
+func (l Libvirt) Disconnected() <-chan struct{} {
+	return l.conn.Disconnected()
+}
+
 func (l Libvirt) Connect() error {
-	if err := l.conn.Connect(); err != nil {
+	if err := l.conn.ConnectToURI(l.driver); err != nil {
 		return fmt.Errorf("failed to communicate with libvirt: %v", err)
 	}
 	return nil
@@ -104,7 +119,7 @@ func (l Libvirt) GetHVStats() (arch string, memoryTotal uint64, memoryFree uint6
 		if i == 0 {
 			continue
 		}
-		archl = append(archl, string(i))
+		archl = append(archl, string(rune(i)))
 	}
 	arch = strings.Join(archl, "")
 
@@ -74,14 +74,23 @@ type HVSpecs struct {
 }
 
 type HVNicSpecs struct {
-	XMLName  xml.Name `xml:"interface"`
-	Text     string   `xml:",chardata"`
-	Type     string   `xml:"type,attr"`
-	Name     string   `xml:"name,attr"`
+	XMLName xml.Name `xml:"interface"`
+	Text    string   `xml:",chardata"`
+	Type    string   `xml:"type,attr"`
+	Name    string   `xml:"name,attr"`
+	Link    struct {
+		Text  string `xml:",chardata"`
+		Speed string `xml:"speed,attr"`
+		State string `xml:"state,attr"`
+	} `xml:"link"`
+	Mac struct {
+		Text    string `xml:",chardata"`
+		Address string `xml:"address,attr"`
+	} `xml:"mac"`
 	Protocol []struct {
 		Text   string `xml:",chardata"`
 		Family string `xml:"family,attr"`
-		Ip     struct {
+		Ip     []struct {
 			Text    string `xml:",chardata"`
 			Address string `xml:"address,attr"`
 			Prefix  string `xml:"prefix,attr"`
@@ -89,7 +98,7 @@ type HVNicSpecs struct {
 	} `xml:"protocol"`
 	Bridge struct {
 		Text      string `xml:",chardata"`
-		Interface struct {
+		Interface []struct {
 			Text string `xml:",chardata"`
 			Type string `xml:"type,attr"`
 			Name string `xml:"name,attr"`
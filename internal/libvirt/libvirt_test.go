@@ -17,3 +17,321 @@
  */
 
 package libvirt_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/testutil"
+	"github.com/BasedDevelopment/auto/internal/util"
+	golibvirt "github.com/digitalocean/go-libvirt"
+	"github.com/google/uuid"
+)
+
+func TestDomDef(t *testing.T) {
+	req := new(util.DomainCreateRequest)
+	if err := json.Unmarshal([]byte(`{
+		"hostname": "web0.example.com",
+		"cpu": 2,
+		"memory": 2048,
+		"os_variant": "ubuntu22.04",
+		"boot": ["hd", "cdrom"],
+		"iface": [
+			{"bridge": "br0", "mac": "52:54:00:12:34:56"},
+			{"bridge": "br1"}
+		]
+	}`), req); err != nil {
+		t.Fatal(err)
+	}
+	nics := []libvirt.DomNic{
+		{Bridge: "br0", MAC: "52:54:00:12:34:56"},
+		{Bridge: "br1", Bandwidth: libvirt.DomBandwidth{
+			Inbound:  libvirt.DomBandwidthLimit{Average: 12800, Peak: 25600, Burst: 1024},
+			Outbound: libvirt.DomBandwidthLimit{Average: 12800},
+		}},
+		{Bridge: "ovs0", Model: "e1000", OVS: true, VLAN: 42},
+		{Bridge: "ovs0", OVS: true, VLAN: 10, Trunk: []int{20, 30}, Queues: 4},
+	}
+
+	id := uuid.MustParse("6f1c2b7e-4d0a-4c8e-9a57-0b2f8f6d1c3a")
+	disks := []libvirt.DomDisk{
+		{Path: "/var/lib/auto/disks/" + id.String() + "/0.qcow2", Format: "qcow2"},
+		{Path: "/var/lib/auto/disks/" + id.String() + "/1.qcow2", Format: "qcow2"},
+		{Path: "/var/lib/auto-cloudinit/" + id.String() + "-cidata.iso", CDROM: true},
+		{Path: "/var/lib/auto/images/debian-12.iso", Format: "raw", CDROM: true},
+	}
+
+	domXml, err := libvirt.NewDomDef(id, req, disks, nics).XML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Golden(t, "domain.xml", domXml)
+}
+
+func TestDomDefDefaultBoot(t *testing.T) {
+	req := &util.DomainCreateRequest{Hostname: "a.example.com", CPU: 1, Memory: 512}
+	def := libvirt.NewDomDef(uuid.New(), req, nil, nil)
+
+	if len(def.Os.Boot) != len(libvirt.DefaultBootOrder) {
+		t.Fatalf("expected %d boot devices, got %d", len(libvirt.DefaultBootOrder), len(def.Os.Boot))
+	}
+	for i, dev := range libvirt.DefaultBootOrder {
+		if def.Os.Boot[i].Dev != dev {
+			t.Errorf("boot device %d: expected %s, got %s", i, dev, def.Os.Boot[i].Dev)
+		}
+	}
+}
+
+func TestDomSpecsMultiNic(t *testing.T) {
+	domXml, err := os.ReadFile(filepath.Join("testdata", "domain.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var specs libvirt.DomSpecs
+	if err := xml.Unmarshal(domXml, &specs); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(specs.Devices.Interface) != 4 {
+		t.Fatalf("expected 4 interfaces, got %d", len(specs.Devices.Interface))
+	}
+	if specs.Devices.Interface[0].Mac.Address != "52:54:00:12:34:56" {
+		t.Errorf("unexpected mac %s", specs.Devices.Interface[0].Mac.Address)
+	}
+	if specs.Devices.Interface[1].Source.Bridge != "br1" {
+		t.Errorf("unexpected bridge %s", specs.Devices.Interface[1].Source.Bridge)
+	}
+	if bw := specs.Devices.Interface[1].Bandwidth; bw.Inbound.Peak != 25600 || bw.Outbound.Average != 12800 {
+		t.Errorf("unexpected bandwidth %+v", bw)
+	}
+	trunk := specs.Devices.Interface[3]
+	if trunk.Virtualport.Type != "openvswitch" || trunk.Vlan.Trunk != "yes" || len(trunk.Vlan.Tag) != 3 {
+		t.Errorf("unexpected trunk %+v", trunk.Vlan)
+	}
+	if trunk.Driver.Queues != 4 {
+		t.Errorf("expected 4 queues, got %d", trunk.Driver.Queues)
+	}
+	if len(specs.Devices.Disk) != 4 {
+		t.Fatalf("expected 4 disks, got %d", len(specs.Devices.Disk))
+	}
+}
+
+func TestParseURI(t *testing.T) {
+	tests := []struct {
+		uri       string
+		transport string
+		host      string
+		port      int
+		socket    string
+		err       bool
+	}{
+		{uri: "unix:///var/run/libvirt/libvirt-sock", transport: libvirt.TransportUnix, socket: "/var/run/libvirt/libvirt-sock"},
+		{uri: "qemu:///system", transport: libvirt.TransportUnix, socket: libvirt.DefaultSocket},
+		{uri: "qemu+unix:///system?socket=/run/libvirt/libvirt-sock", transport: libvirt.TransportUnix, socket: "/run/libvirt/libvirt-sock"},
+		{uri: "qemu+tcp://10.0.0.1/system", transport: libvirt.TransportTCP, host: "10.0.0.1", port: libvirt.DefaultTCPPort},
+		{uri: "qemu+tcp://10.0.0.1:1234/system", transport: libvirt.TransportTCP, host: "10.0.0.1", port: 1234},
+		{uri: "qemu+tls://dev0.nyc1.bns.sh/system", transport: libvirt.TransportTLS, host: "dev0.nyc1.bns.sh", port: libvirt.DefaultTLSPort},
+		{uri: "qemu+tcp:///system", err: true},
+		{uri: "qemu+tcp://10.0.0.1:99999/system", err: true},
+		{uri: "qemu+ssh://10.0.0.1/system", err: true},
+		{uri: "unix://host/var/run/libvirt/libvirt-sock", err: true},
+	}
+
+	for _, tt := range tests {
+		c, err := libvirt.ParseURI(tt.uri)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.uri)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.uri, err)
+			continue
+		}
+		if c.Transport != tt.transport || c.Host != tt.host || c.Port != tt.port || c.Socket != tt.socket {
+			t.Errorf("%s: got %+v", tt.uri, c)
+		}
+	}
+
+	if got := libvirt.TCPURI("10.0.0.1", 1234); got != "qemu+tcp://10.0.0.1:1234/system" {
+		t.Errorf("unexpected TCP URI %s", got)
+	}
+}
+
+func TestDeviceXML(t *testing.T) {
+	disk := libvirt.DomDisk{Path: "/dev/ssd/dom-1", Format: "raw", Block: true}
+	got, err := libvirt.DeviceXML("disk", disk.Def("vdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<disk type="block" device="disk">
+  <driver name="qemu" type="raw"></driver>
+  <source dev="/dev/ssd/dom-1"></source>
+  <target dev="vdb" bus="virtio"></target>
+</disk>`
+	if got != want {
+		t.Errorf("disk mismatch\n--- got ---\n%s\n--- want ---\n%s", got, want)
+	}
+
+	nic := libvirt.DomNic{Bridge: "ovs0", MAC: "52:54:00:12:34:56", OVS: true, VLAN: 42}
+	got, err = libvirt.DeviceXML("interface", nic.Def())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `<interface type="bridge">
+  <mac address="52:54:00:12:34:56"></mac>
+  <source bridge="ovs0"></source>
+  <vlan>
+    <tag id="42"></tag>
+  </vlan>
+  <virtualport type="openvswitch"></virtualport>
+  <model type="virtio"></model>
+</interface>`
+	if got != want {
+		t.Errorf("interface mismatch\n--- got ---\n%s\n--- want ---\n%s", got, want)
+	}
+}
+
+func readTestdata(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestUpdateDomainXML(t *testing.T) {
+	vcpus := 8
+	mem := uint64(6 * 1024 * 1024)
+	max := uint64(8 * 1024 * 1024)
+	desc := "new <web> server"
+
+	got, err := libvirt.UpdateDomainXML(readTestdata(t, "inactive.xml"), libvirt.DomConfigUpdate{
+		Vcpus:       &vcpus,
+		Memory:      &mem,
+		MaxMemory:   &max,
+		Description: &desc,
+		Boot:        []string{"network", "hd"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Golden(t, "inactive_updated.xml", got)
+}
+
+func TestUpdateDomainXMLUntouched(t *testing.T) {
+	domXml := readTestdata(t, "inactive.xml")
+
+	// Nothing asked, nothing changed, down to the quoting
+	got, err := libvirt.UpdateDomainXML(domXml, libvirt.DomConfigUpdate{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != domXml {
+		t.Errorf("domain changed\n--- got ---\n%s", got)
+	}
+}
+
+func TestUpdateDomainXMLMemory(t *testing.T) {
+	kib := func(v uint64) *uint64 { return &v }
+	desc, none := "web", ""
+
+	for _, tc := range []struct {
+		name   string
+		xml    string
+		update libvirt.DomConfigUpdate
+		want   []string
+		err    error
+	}{
+		{
+			name:   "memory over max",
+			xml:    "inactive.xml",
+			update: libvirt.DomConfigUpdate{Memory: kib(8 * 1024 * 1024)},
+			err:    libvirt.ErrMemoryOverMax,
+		},
+		{
+			name:   "memory over new max",
+			xml:    "inactive.xml",
+			update: libvirt.DomConfigUpdate{Memory: kib(3 * 1024 * 1024), MaxMemory: kib(2 * 1024 * 1024)},
+			err:    libvirt.ErrMemoryOverMax,
+		},
+		{
+			name:   "lower max takes memory down",
+			xml:    "inactive.xml",
+			update: libvirt.DomConfigUpdate{MaxMemory: kib(1024 * 1024)},
+			want: []string{
+				`<memory unit="KiB">1048576</memory>`,
+				`<currentMemory unit="KiB">1048576</currentMemory>`,
+			},
+		},
+		{
+			name:   "units converted",
+			xml:    "domain.xml",
+			update: libvirt.DomConfigUpdate{Memory: kib(1024 * 1024)},
+			want: []string{
+				`<memory unit="KiB">2097152</memory>`,
+				`<currentMemory unit="KiB">1048576</currentMemory>`,
+			},
+		},
+		{
+			name:   "description added",
+			xml:    "domain.xml",
+			update: libvirt.DomConfigUpdate{Description: &desc},
+			want:   []string{"<description>web</description>\n</domain>"},
+		},
+		{
+			name:   "description removed",
+			xml:    "inactive.xml",
+			update: libvirt.DomConfigUpdate{Description: &none},
+			want:   []string{"</uuid>\n  \n  <metadata>"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := libvirt.UpdateDomainXML(readTestdata(t, tc.xml), tc.update)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v, got %v", tc.err, err)
+			}
+			for _, w := range tc.want {
+				if !strings.Contains(got, w) {
+					t.Errorf("expected %q in\n%s", w, got)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateDomainXMLNoOs(t *testing.T) {
+	_, err := libvirt.UpdateDomainXML("<domain type='kvm'><name>x</name></domain>", libvirt.DomConfigUpdate{Boot: []string{"hd"}})
+	if err == nil {
+		t.Error("expected a domain without os to fail")
+	}
+}
+
+func TestIsSnapshotNotFound(t *testing.T) {
+	missing := golibvirt.Error{Code: uint32(golibvirt.ErrNoDomainSnapshot), Message: "no snapshot"}
+	if !libvirt.IsSnapshotNotFound(missing) {
+		t.Error("ErrNoDomainSnapshot not detected")
+	}
+	if !libvirt.IsSnapshotNotFound(fmt.Errorf("lookup: %w", missing)) {
+		t.Error("wrapped ErrNoDomainSnapshot not detected")
+	}
+	if libvirt.IsSnapshotNotFound(errors.New("connection reset by peer")) {
+		t.Error("connection error taken for a missing snapshot")
+	}
+	if libvirt.IsSnapshotNotFound(golibvirt.Error{Code: uint32(golibvirt.ErrNoDomain)}) {
+		t.Error("missing domain taken for a missing snapshot")
+	}
+	if libvirt.IsSnapshotNotFound(nil) {
+		t.Error("nil taken for a missing snapshot")
+	}
+}
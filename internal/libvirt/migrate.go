@@ -0,0 +1,140 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"errors"
+
+	"github.com/digitalocean/go-libvirt"
+)
+
+type MigrateOptions struct {
+	// Keep the domain running during the migration, otherwise it's paused
+	Live bool
+	// Copy the disks along for hosts without shared storage
+	CopyStorage bool
+	// MiB/s, 0 leaves it to libvirt
+	Bandwidth uint64
+}
+
+// Statistics of the job running on a domain, sizes in bytes and times in ms
+type JobStats struct {
+	Type            libvirt.DomainJobType
+	TimeElapsed     uint64
+	TimeRemaining   uint64
+	DataTotal       uint64
+	DataProcessed   uint64
+	DataRemaining   uint64
+	MemoryRemaining uint64
+	MemoryBps       uint64
+	DiskRemaining   uint64
+	Iteration       uint64
+	// Expected downtime of the switchover
+	Downtime uint64
+}
+
+// Offline migration only moves the definition, the disks stay behind
+var ErrOfflineCopyStorage = errors.New("storage can't be copied for a shut off domain")
+
+// Peer-to-peer migration, the source libvirtd connects to destURI itself.
+// The domain is persisted on the destination and undefined here once done,
+// inactive domains are migrated offline, which only moves the definition, so
+// callers have to make sure the destination sees the same disks.
+func (l Libvirt) MigrateVM(dom Dom, destURI string, opts MigrateOptions) error {
+	active, err := l.IsVMActive(dom)
+	if err != nil {
+		return err
+	}
+
+	flags := libvirt.MigratePeer2peer | libvirt.MigratePersistDest | libvirt.MigrateUndefineSource
+	if !active {
+		if opts.CopyStorage {
+			return ErrOfflineCopyStorage
+		}
+		flags |= libvirt.MigrateOffline
+	} else {
+		flags |= libvirt.MigrateAbortOnError
+		if opts.Live {
+			flags |= libvirt.MigrateLive
+		}
+		if opts.CopyStorage {
+			flags |= libvirt.MigrateNonSharedDisk
+		}
+	}
+
+	var params []libvirt.TypedParam
+	if opts.Bandwidth != 0 {
+		params = append(params, libvirt.TypedParam{
+			Field: libvirt.MigrateParamBandwidth,
+			Value: *libvirt.NewTypedParamValueUllong(opts.Bandwidth),
+		})
+	}
+
+	_, err = l.conn.DomainMigratePerform3Params(dom.Dom, libvirt.OptString{destURI}, params, nil, flags)
+	return err
+}
+
+func (l Libvirt) GetVMJobStats(dom Dom) (stats JobStats, err error) {
+	t, params, err := l.conn.DomainGetJobStats(dom.Dom, 0)
+	if err != nil {
+		return
+	}
+	stats.Type = libvirt.DomainJobType(t)
+
+	for _, p := range params {
+		v, ok := p.Value.I.(uint64)
+		if !ok {
+			continue
+		}
+		switch p.Field {
+		case libvirt.DomainJobTimeElapsed:
+			stats.TimeElapsed = v
+		case libvirt.DomainJobTimeRemaining:
+			stats.TimeRemaining = v
+		case libvirt.DomainJobDataTotal:
+			stats.DataTotal = v
+		case libvirt.DomainJobDataProcessed:
+			stats.DataProcessed = v
+		case libvirt.DomainJobDataRemaining:
+			stats.DataRemaining = v
+		case libvirt.DomainJobMemoryRemaining:
+			stats.MemoryRemaining = v
+		case libvirt.DomainJobMemoryBps:
+			stats.MemoryBps = v
+		case libvirt.DomainJobDiskRemaining:
+			stats.DiskRemaining = v
+		case libvirt.DomainJobMemoryIteration:
+			stats.Iteration = v
+		case libvirt.DomainJobDowntime:
+			stats.Downtime = v
+		}
+	}
+	return
+}
+
+// Aborts the job running on the domain, a cancelled migration leaves the
+// domain running here
+func (l Libvirt) AbortVMJob(dom Dom) error {
+	return l.conn.DomainAbortJob(dom.Dom)
+}
+
+// Whether a job is still running, libvirt reports finished ones as well
+func (s JobStats) Active() bool {
+	return s.Type == libvirt.DomainJobBounded || s.Type == libvirt.DomainJobUnbounded
+}
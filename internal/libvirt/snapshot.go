@@ -0,0 +1,157 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+
+	"github.com/digitalocean/go-libvirt"
+)
+
+// Snapshot of a domain
+type Snap struct {
+	Snap libvirt.DomainSnapshot
+}
+
+// The parts of the snapshot XML we care about
+type SnapSpecs struct {
+	XMLName      xml.Name `xml:"domainsnapshot"`
+	Name         string   `xml:"name"`
+	Description  string   `xml:"description"`
+	State        string   `xml:"state"`
+	CreationTime int64    `xml:"creationTime"`
+	Parent       struct {
+		Name string `xml:"name"`
+	} `xml:"parent"`
+	Memory struct {
+		Snapshot string `xml:"snapshot,attr"`
+	} `xml:"memory"`
+	Disks struct {
+		Disk []struct {
+			Name     string `xml:"name,attr"`
+			Snapshot string `xml:"snapshot,attr"`
+			Source   struct {
+				File string `xml:"file,attr"`
+			} `xml:"source"`
+		} `xml:"disk"`
+	} `xml:"disks"`
+}
+
+// Disk-only snapshots are kept in overlays, libvirt can't revert or delete
+// those
+func (s SnapSpecs) External() bool {
+	for _, disk := range s.Disks.Disk {
+		if disk.Snapshot == "external" {
+			return true
+		}
+	}
+	return false
+}
+
+type snapDef struct {
+	XMLName     xml.Name `xml:"domainsnapshot"`
+	Name        string   `xml:"name"`
+	Description string   `xml:"description,omitempty"`
+}
+
+// Creates a snapshot, internal ones include the memory of a running domain,
+// external ones are disk-only overlays and can be quiesced through the guest
+// agent
+func (l Libvirt) CreateSnapshot(dom Dom, name string, description string, external bool, quiesce bool) (snap Snap, err error) {
+	b, err := xml.Marshal(snapDef{Name: name, Description: description})
+	if err != nil {
+		return
+	}
+
+	flags := libvirt.DomainSnapshotCreateAtomic
+	if external {
+		flags |= libvirt.DomainSnapshotCreateDiskOnly
+		if quiesce {
+			flags |= libvirt.DomainSnapshotCreateQuiesce
+		}
+	}
+
+	s, err := l.conn.DomainSnapshotCreateXML(dom.Dom, string(b), uint32(flags))
+	if err != nil {
+		return snap, fmt.Errorf("failed to create snapshot: %v", err)
+	}
+	return Snap{s}, nil
+}
+
+func (l Libvirt) GetSnapshots(dom Dom) (snaps []Snap, err error) {
+	list, _, err := l.conn.DomainListAllSnapshots(dom.Dom, 1, 0)
+	if err != nil {
+		return
+	}
+	for _, s := range list {
+		snaps = append(snaps, Snap{s})
+	}
+	return
+}
+
+func (l Libvirt) GetSnapshot(dom Dom, name string) (Snap, error) {
+	s, err := l.conn.DomainSnapshotLookupByName(dom.Dom, name, 0)
+	return Snap{s}, err
+}
+
+// Whether the error is libvirt not knowing the snapshot, rather than the call
+// failing
+func IsSnapshotNotFound(err error) bool {
+	var e libvirt.Error
+	return errors.As(err, &e) && e.Code == uint32(libvirt.ErrNoDomainSnapshot)
+}
+
+func (l Libvirt) GetSnapshotSpecs(snap Snap) (specs SnapSpecs, err error) {
+	snapXml, err := l.conn.DomainSnapshotGetXMLDesc(snap.Snap, 0)
+	if err != nil {
+		return
+	}
+	err = xml.Unmarshal([]byte(snapXml), &specs)
+	return
+}
+
+func (l Libvirt) IsCurrentSnapshot(snap Snap) (bool, error) {
+	current, err := l.conn.DomainSnapshotIsCurrent(snap.Snap, 0)
+	return current == 1, err
+}
+
+// Reverts to the snapshot, the domain ends up in the state it was in when the
+// snapshot was taken
+func (l Libvirt) RevertSnapshot(snap Snap) error {
+	return l.conn.DomainRevertToSnapshot(snap.Snap, 0)
+}
+
+// Deletes the snapshot, its children are reparented to its parent
+func (l Libvirt) DeleteSnapshot(snap Snap) error {
+	return l.conn.DomainSnapshotDelete(snap.Snap, 0)
+}
+
+// Freezes the guest filesystems through the guest agent, for snapshots taken
+// outside of libvirt
+func (l Libvirt) FreezeVMFS(dom Dom) error {
+	_, err := l.conn.DomainFsfreeze(dom.Dom, nil, 0)
+	return err
+}
+
+func (l Libvirt) ThawVMFS(dom Dom) error {
+	_, err := l.conn.DomainFsthaw(dom.Dom, nil, 0)
+	return err
+}
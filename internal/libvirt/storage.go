@@ -0,0 +1,94 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/google/uuid"
+)
+
+// Prefix of the libvirt pools backing auto's storages
+const PoolPrefix = "auto-"
+
+type Pool struct {
+	Pool libvirt.StoragePool
+}
+
+func (p Pool) UUID() uuid.UUID {
+	return uuid.UUID(p.Pool.UUID)
+}
+
+type poolDef struct {
+	XMLName xml.Name `xml:"pool"`
+	Type    string   `xml:"type,attr"`
+	Name    string   `xml:"name"`
+	Target  struct {
+		Path string `xml:"path"`
+	} `xml:"target"`
+}
+
+// Looks up the dir pool of a storage, defining, building and starting it as
+// needed. The pool is autostarted so it's around after a libvirtd restart.
+func (l Libvirt) EnsureDirPool(name string, path string) (p Pool, err error) {
+	pool, err := l.conn.StoragePoolLookupByName(PoolPrefix + name)
+	if err != nil {
+		def := poolDef{Type: "dir", Name: PoolPrefix + name}
+		def.Target.Path = path
+		b, err := xml.Marshal(def)
+		if err != nil {
+			return p, err
+		}
+
+		pool, err = l.conn.StoragePoolDefineXML(string(b), 0)
+		if err != nil {
+			return p, fmt.Errorf("failed to define pool: %v", err)
+		}
+		if err := l.conn.StoragePoolBuild(pool, libvirt.StoragePoolBuildNoOverwrite); err != nil {
+			return p, fmt.Errorf("failed to build pool: %v", err)
+		}
+		if err := l.conn.StoragePoolSetAutostart(pool, 1); err != nil {
+			return p, fmt.Errorf("failed to autostart pool: %v", err)
+		}
+	}
+	p.Pool = pool
+
+	active, err := l.conn.StoragePoolIsActive(pool)
+	if err != nil {
+		return
+	}
+	if active != 1 {
+		if err := l.conn.StoragePoolCreate(pool, libvirt.StoragePoolCreateNormal); err != nil {
+			return p, fmt.Errorf("failed to start pool: %v", err)
+		}
+	}
+	return
+}
+
+// Capacity, allocation and available space of a pool in bytes
+func (l Libvirt) GetPoolInfo(p Pool) (capacity uint64, allocation uint64, available uint64, err error) {
+	// The numbers are only as fresh as the last refresh
+	if err = l.conn.StoragePoolRefresh(p.Pool, 0); err != nil {
+		return
+	}
+	_, capacity, allocation, available, err = l.conn.StoragePoolGetInfo(p.Pool)
+	return
+}
@@ -0,0 +1,160 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/digitalocean/go-libvirt/socket"
+	"github.com/digitalocean/go-libvirt/socket/dialers"
+)
+
+// Transports of the connection URI
+const (
+	TransportUnix = "unix"
+	TransportTCP  = "tcp"
+	TransportTLS  = "tls"
+)
+
+const (
+	DefaultSocket  = "/var/run/libvirt/libvirt-sock"
+	DefaultTCPPort = 16509
+	DefaultTLSPort = 16514
+
+	dialTimeout = 2 * time.Second
+)
+
+// A parsed libvirt connection URI, one of:
+//
+//	unix:///var/run/libvirt/libvirt-sock
+//	qemu+unix:///system?socket=/var/run/libvirt/libvirt-sock
+//	qemu+tcp://host[:port]/system
+//	qemu+tls://host[:port]/system
+type ConnURI struct {
+	Transport string
+	Host      string
+	Port      int
+	Socket    string
+	Driver    libvirt.ConnectURI
+}
+
+func ParseURI(uri string) (c ConnURI, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return c, fmt.Errorf("invalid libvirt URI: %v", err)
+	}
+
+	c.Driver = libvirt.QEMUSystem
+	if u.Path == "/session" {
+		c.Driver = libvirt.QEMUSession
+	}
+
+	switch u.Scheme {
+	case "unix":
+		// The path is the socket itself
+		c.Transport = TransportUnix
+		c.Socket = u.Path
+		c.Driver = libvirt.QEMUSystem
+	case "qemu+unix", "qemu":
+		c.Transport = TransportUnix
+		c.Socket = u.Query().Get("socket")
+	case "qemu+tcp":
+		c.Transport = TransportTCP
+		c.Port = DefaultTCPPort
+	case "qemu+tls":
+		c.Transport = TransportTLS
+		c.Port = DefaultTLSPort
+	default:
+		return c, fmt.Errorf("unsupported libvirt URI scheme: %q", u.Scheme)
+	}
+
+	if c.Transport == TransportUnix {
+		if u.Host != "" {
+			return c, errors.New("unix libvirt URIs can't have a host")
+		}
+		if c.Socket == "" {
+			c.Socket = DefaultSocket
+		}
+		return c, nil
+	}
+
+	c.Host = u.Hostname()
+	if c.Host == "" {
+		return c, fmt.Errorf("%s libvirt URIs need a host", c.Transport)
+	}
+	if p := u.Port(); p != "" {
+		c.Port, err = strconv.Atoi(p)
+		if err != nil || c.Port < 1 || c.Port > 65535 {
+			return c, fmt.Errorf("invalid libvirt port: %q", p)
+		}
+	}
+	return c, nil
+}
+
+// The URI for the old host/port config, which always meant plain TCP
+func TCPURI(host string, port int) string {
+	return "qemu+tcp://" + net.JoinHostPort(host, strconv.Itoa(port)) + "/system"
+}
+
+// Picks the dialer matching the URI transport, tlsConfig is only used for TLS
+func (c ConnURI) dialer(tlsConfig *tls.Config) (socket.Dialer, error) {
+	switch c.Transport {
+	case TransportUnix:
+		return dialers.NewLocal(
+			dialers.WithSocket(c.Socket),
+			dialers.WithLocalTimeout(dialTimeout),
+		), nil
+	case TransportTCP:
+		return dialers.NewRemote(
+			c.Host,
+			dialers.UsePort(strconv.Itoa(c.Port)),
+			dialers.WithRemoteTimeout(dialTimeout),
+		), nil
+	case TransportTLS:
+		if tlsConfig == nil {
+			return nil, errors.New("TLS libvirt URI without TLS configuration")
+		}
+		cfg := tlsConfig.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName = c.Host
+		}
+		return &tlsDialer{
+			addr:   net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+			config: cfg,
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported transport %q", c.Transport)
+}
+
+// go-libvirt only ships local and plain TCP dialers
+type tlsDialer struct {
+	addr   string
+	config *tls.Config
+}
+
+func (d *tlsDialer) Dial() (net.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", d.addr, d.config)
+}
@@ -22,6 +22,7 @@ import (
 	"encoding/hex"
 	"encoding/xml"
 	"errors"
+	"fmt"
 
 	"github.com/BasedDevelopment/eve/pkg/status"
 	"github.com/digitalocean/go-libvirt"
@@ -33,6 +34,10 @@ type Dom struct {
 	Dom libvirt.Domain
 }
 
+func domUUID(dom libvirt.Domain) uuid.UUID {
+	return uuid.MustParse(hex.EncodeToString(dom.UUID[:]))
+}
+
 // Fetches list of all defined domains
 // Won't be used to populate the HV's VM list, instead to check for inconsistencies
 func (l Libvirt) GetVMs() (vms map[uuid.UUID]Dom, err error) {
@@ -42,9 +47,7 @@ func (l Libvirt) GetVMs() (vms map[uuid.UUID]Dom, err error) {
 	}
 	vms = make(map[uuid.UUID]Dom)
 	for _, dom := range doms {
-		vmuuidstr := hex.EncodeToString(dom.UUID[:])
-		vmuuid := uuid.MustParse(vmuuidstr)
-		vms[vmuuid] = Dom{dom}
+		vms[domUUID(dom)] = Dom{dom}
 	}
 	return
 }
@@ -57,9 +60,7 @@ func (l Libvirt) GetUndefinedVMs() (vms map[uuid.UUID]Dom, err error) {
 	}
 	vms = make(map[uuid.UUID]Dom)
 	for _, dom := range doms {
-		vmuuidstr := hex.EncodeToString(dom.UUID[:])
-		vmuuid := uuid.MustParse(vmuuidstr)
-		vms[vmuuid] = Dom{dom}
+		vms[domUUID(dom)] = Dom{dom}
 	}
 	return
 }
@@ -89,6 +90,11 @@ func (l Libvirt) GetVMSpecs(dom Dom) (specs DomSpecs, err error) {
 	return
 }
 
+// Get the allocation, capacity and physical size in bytes of a disk
+func (l Libvirt) GetVMBlockInfo(dom Dom, dev string) (allocation uint64, capacity uint64, physical uint64, err error) {
+	return l.conn.DomainGetBlockInfo(dom.Dom, dev, 0)
+}
+
 // Get the state of a domain(vm)
 func (l Libvirt) GetVMState(dom Dom) (stateInt status.Status, stateStr string, reasonStr string, err error) {
 	//https://libvirt.org/html/libvirt-libvirt-domain.html#virDomainState
@@ -120,6 +126,21 @@ func (l Libvirt) GetVMConsole(dom Dom) (port string, err error) {
 	return "", errors.New("no console found")
 }
 
+// Defines a persistent domain from its definition
+func (l Libvirt) DefineVM(def DomDef) (dom Dom, err error) {
+	domXml, err := def.XML()
+	if err != nil {
+		return
+	}
+
+	domain, err := l.conn.DomainDefineXML(domXml)
+	if err != nil {
+		return dom, fmt.Errorf("failed to define domain: %v", err)
+	}
+	dom = Dom{domain}
+	return
+}
+
 func (l Libvirt) VMStart(dom Dom) (err error) {
 	return l.conn.DomainCreate(dom.Dom)
 }
@@ -128,8 +149,23 @@ func (l Libvirt) VMReboot(dom Dom) error {
 	return l.conn.DomainReboot(dom.Dom, libvirt.DomainRebootDefault)
 }
 
-func (l Libvirt) VMPowerOff(dom Dom) error {
-	return l.conn.DomainShutdownFlags(dom.Dom, libvirt.DomainShutdownDefault)
+// Shutdown methods accepted by VMPowerOff, empty lets the hypervisor pick
+var ShutdownMethods = map[string]libvirt.DomainShutdownFlagValues{
+	"":         libvirt.DomainShutdownDefault,
+	"acpi":     libvirt.DomainShutdownAcpiPowerBtn,
+	"agent":    libvirt.DomainShutdownGuestAgent,
+	"initctl":  libvirt.DomainShutdownInitctl,
+	"signal":   libvirt.DomainShutdownSignal,
+	"paravirt": libvirt.DomainShutdownParavirt,
+}
+
+// Asks the guest to shut down, this returns before the guest actually does
+func (l Libvirt) VMPowerOff(dom Dom, method string) error {
+	flags, ok := ShutdownMethods[method]
+	if !ok {
+		return fmt.Errorf("unknown shutdown method: %s", method)
+	}
+	return l.conn.DomainShutdownFlags(dom.Dom, flags)
 }
 
 func (l Libvirt) VMStop(dom Dom) error {
@@ -140,10 +176,51 @@ func (l Libvirt) VMReset(dom Dom) error {
 	return l.conn.DomainReset(dom.Dom, 0)
 }
 
+func (l Libvirt) VMPause(dom Dom) error {
+	return l.conn.DomainSuspend(dom.Dom)
+}
+
+func (l Libvirt) VMResume(dom Dom) error {
+	return l.conn.DomainResume(dom.Dom)
+}
+
+// Suspend to RAM through the guest agent, until woken up
+func (l Libvirt) VMPMSuspend(dom Dom) error {
+	return l.conn.DomainPmSuspendForDuration(dom.Dom, uint32(libvirt.NodeSuspendTargetMem), 0, 0)
+}
+
+func (l Libvirt) VMPMWakeup(dom Dom) error {
+	return l.conn.DomainPmWakeup(dom.Dom, 0)
+}
+
+// Saves the domain memory to disk and stops it, the next start restores it
+func (l Libvirt) VMManagedSave(dom Dom) error {
+	return l.conn.DomainManagedSave(dom.Dom, 0)
+}
+
+func (l Libvirt) VMHasManagedSave(dom Dom) (bool, error) {
+	has, err := l.conn.DomainHasManagedSaveImage(dom.Dom, 0)
+	return has == 1, err
+}
+
 func (l Libvirt) DestroyVM(dom Dom) error {
 	return l.conn.DomainDestroy(dom.Dom)
 }
 
+// Whether the domain is running (or paused), which is when it has to be
+// destroyed before it can be undefined cleanly
+func (l Libvirt) IsVMActive(dom Dom) (bool, error) {
+	active, err := l.conn.DomainIsActive(dom.Dom)
+	return active == 1, err
+}
+
+// Undefines the domain along with its NVRAM, managed save image and
+// snapshot/checkpoint metadata, leaving nothing behind in libvirt
 func (l Libvirt) UndefineVM(dom Dom) error {
-	return l.conn.DomainUndefine(dom.Dom)
+	return l.conn.DomainUndefineFlags(dom.Dom,
+		libvirt.DomainUndefineManagedSave|
+			libvirt.DomainUndefineSnapshotsMetadata|
+			libvirt.DomainUndefineNvram|
+			libvirt.DomainUndefineCheckpointsMetadata,
+	)
 }
@@ -0,0 +1,421 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+
+	"github.com/BasedDevelopment/auto/internal/util"
+	"github.com/google/uuid"
+)
+
+// Namespace of the auto specific metadata stored in the domain XML
+const MetadataNS = "https://github.com/BasedDevelopment/auto"
+
+// Address the graphics of new domains listen on
+const GraphicsListen = "127.0.0.1"
+
+// Boot devices used when the request does not specify any
+var DefaultBootOrder = []string{"cdrom", "hd"}
+
+// A disk or cdrom to attach to a new domain, the paths are resolved by the
+// caller since the storage layout is not known here
+type DomDisk struct {
+	Path   string
+	Format string
+	CDROM  bool
+	// Path is a block device rather than a file
+	Block bool
+}
+
+// A NIC to plug into a bridge, OVS bridges take VLANs: VLAN alone is an
+// access port, with Trunk it's the native VLAN of a trunk
+type DomNic struct {
+	Bridge string
+	MAC    string
+	Model  string
+	OVS    bool
+	VLAN   int
+	Trunk  []int
+	// More than one enables multiqueue
+	Queues    int
+	Bandwidth DomBandwidth
+}
+
+// Bandwidth limits of a NIC the way libvirt takes them, average and peak in
+// KiB/s and burst in KiB. Inbound is traffic towards the domain, a zero
+// average is no limit.
+type DomBandwidth struct {
+	Inbound  DomBandwidthLimit
+	Outbound DomBandwidthLimit
+}
+
+type DomBandwidthLimit struct {
+	Average uint32
+	Peak    uint32
+	Burst   uint32
+}
+
+// Domain definition, rendered to XML and passed to DomainDefineXML.
+// Unlike DomSpecs, this only carries the fields we set ourselves.
+type DomDef struct {
+	XMLName       xml.Name       `xml:"domain"`
+	Type          string         `xml:"type,attr"`
+	Name          string         `xml:"name"`
+	Uuid          string         `xml:"uuid"`
+	Metadata      DomDefMetadata `xml:"metadata"`
+	Memory        DomDefMemory   `xml:"memory"`
+	CurrentMemory DomDefMemory   `xml:"currentMemory"`
+	Vcpu          DomDefVcpu     `xml:"vcpu"`
+	Os            DomDefOs       `xml:"os"`
+	Features      struct {
+		Acpi struct{} `xml:"acpi"`
+		Apic struct{} `xml:"apic"`
+	} `xml:"features"`
+	Cpu struct {
+		Mode string `xml:"mode,attr"`
+	} `xml:"cpu"`
+	Clock struct {
+		Offset string `xml:"offset,attr"`
+	} `xml:"clock"`
+	OnPoweroff string        `xml:"on_poweroff"`
+	OnReboot   string        `xml:"on_reboot"`
+	OnCrash    string        `xml:"on_crash"`
+	Devices    DomDefDevices `xml:"devices"`
+}
+
+type DomDefMetadata struct {
+	Instance DomDefInstance `xml:"https://github.com/BasedDevelopment/auto instance"`
+}
+
+// Auto's own metadata, libvirt keeps it untouched in the domain XML
+type DomDefInstance struct {
+	Hostname  string `xml:"hostname"`
+	OSVariant string `xml:"os_variant,omitempty"`
+}
+
+type DomDefMemory struct {
+	Text string `xml:",chardata"`
+	Unit string `xml:"unit,attr"`
+}
+
+type DomDefVcpu struct {
+	Text      string `xml:",chardata"`
+	Placement string `xml:"placement,attr"`
+}
+
+type DomDefOs struct {
+	Type struct {
+		Text string `xml:",chardata"`
+	} `xml:"type"`
+	Boot     []DomDefBoot `xml:"boot"`
+	Bootmenu struct {
+		Enable string `xml:"enable,attr"`
+	} `xml:"bootmenu"`
+}
+
+type DomDefBoot struct {
+	Dev string `xml:"dev,attr"`
+}
+
+type DomDefDevices struct {
+	Disk      []DomDefDisk      `xml:"disk"`
+	Interface []DomDefInterface `xml:"interface"`
+	Serial    struct {
+		Type string `xml:"type,attr"`
+	} `xml:"serial"`
+	Console struct {
+		Type   string `xml:"type,attr"`
+		Target struct {
+			Type string `xml:"type,attr"`
+		} `xml:"target"`
+	} `xml:"console"`
+	Channel struct {
+		Type   string `xml:"type,attr"`
+		Target struct {
+			Type string `xml:"type,attr"`
+			Name string `xml:"name,attr"`
+		} `xml:"target"`
+	} `xml:"channel"`
+	Input struct {
+		Type string `xml:"type,attr"`
+		Bus  string `xml:"bus,attr"`
+	} `xml:"input"`
+	Graphics []DomDefGraphics `xml:"graphics"`
+	Video    struct {
+		Model struct {
+			Type string `xml:"type,attr"`
+		} `xml:"model"`
+	} `xml:"video"`
+	Memballoon struct {
+		Model string `xml:"model,attr"`
+	} `xml:"memballoon"`
+	Rng struct {
+		Model   string `xml:"model,attr"`
+		Backend struct {
+			Text  string `xml:",chardata"`
+			Model string `xml:"model,attr"`
+		} `xml:"backend"`
+	} `xml:"rng"`
+}
+
+type DomDefDisk struct {
+	Type   string `xml:"type,attr"`
+	Device string `xml:"device,attr"`
+	Driver struct {
+		Name string `xml:"name,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"driver"`
+	Source struct {
+		File string `xml:"file,attr,omitempty"`
+		Dev  string `xml:"dev,attr,omitempty"`
+	} `xml:"source"`
+	Target struct {
+		Dev string `xml:"dev,attr"`
+		Bus string `xml:"bus,attr"`
+	} `xml:"target"`
+	Readonly *struct{} `xml:"readonly"`
+}
+
+type DomDefInterface struct {
+	Type   string     `xml:"type,attr"`
+	Mac    *DomDefMac `xml:"mac"`
+	Source struct {
+		Bridge string `xml:"bridge,attr"`
+	} `xml:"source"`
+	Vlan        *DomDefVlan        `xml:"vlan"`
+	Virtualport *DomDefVirtualport `xml:"virtualport"`
+	Model       struct {
+		Type string `xml:"type,attr"`
+	} `xml:"model"`
+	Driver    *DomDefNicDriver `xml:"driver"`
+	Bandwidth *DomDefBandwidth `xml:"bandwidth"`
+}
+
+type DomDefBandwidth struct {
+	Inbound  *DomDefBandwidthLimit `xml:"inbound"`
+	Outbound *DomDefBandwidthLimit `xml:"outbound"`
+}
+
+type DomDefBandwidthLimit struct {
+	Average uint32 `xml:"average,attr"`
+	Peak    uint32 `xml:"peak,attr,omitempty"`
+	Burst   uint32 `xml:"burst,attr,omitempty"`
+}
+
+type DomDefVlan struct {
+	Trunk string          `xml:"trunk,attr,omitempty"`
+	Tag   []DomDefVlanTag `xml:"tag"`
+}
+
+type DomDefVlanTag struct {
+	ID         int    `xml:"id,attr"`
+	NativeMode string `xml:"nativeMode,attr,omitempty"`
+}
+
+type DomDefVirtualport struct {
+	Type string `xml:"type,attr"`
+}
+
+type DomDefNicDriver struct {
+	Name   string `xml:"name,attr"`
+	Queues int    `xml:"queues,attr"`
+}
+
+type DomDefMac struct {
+	Address string `xml:"address,attr"`
+}
+
+type DomDefGraphics struct {
+	Type      string `xml:"type,attr"`
+	Port      string `xml:"port,attr"`
+	Autoport  string `xml:"autoport,attr"`
+	Websocket string `xml:"websocket,attr"`
+	Listen    struct {
+		Type    string `xml:"type,attr"`
+		Address string `xml:"address,attr"`
+	} `xml:"listen"`
+}
+
+// Builds the domain definition for a create request, disks are attached in
+// the order given, regular disks on virtio and cdroms on sata
+func NewDomDef(id uuid.UUID, req *util.DomainCreateRequest, disks []DomDisk, nics []DomNic) (def DomDef) {
+	def.Type = "kvm"
+	def.Name = req.Hostname
+	def.Uuid = id.String()
+	def.Metadata.Instance = DomDefInstance{
+		Hostname:  req.Hostname,
+		OSVariant: req.OSVariant,
+	}
+
+	// Memory is requested in MiB, same as virt-install used to take it
+	def.Memory = DomDefMemory{Text: strconv.Itoa(req.Memory), Unit: "MiB"}
+	def.CurrentMemory = def.Memory
+	def.Vcpu = DomDefVcpu{Text: strconv.Itoa(req.CPU), Placement: "static"}
+
+	def.Os.Type.Text = "hvm"
+	boot := req.Boot
+	if len(boot) == 0 {
+		boot = DefaultBootOrder
+	}
+	for _, dev := range boot {
+		def.Os.Boot = append(def.Os.Boot, DomDefBoot{dev})
+	}
+	def.Os.Bootmenu.Enable = "yes"
+
+	def.Cpu.Mode = "host-model"
+	def.Clock.Offset = "utc"
+	def.OnPoweroff = "destroy"
+	def.OnReboot = "restart"
+	def.OnCrash = "destroy"
+
+	var vd, sd int
+	for _, disk := range disks {
+		var dev string
+		if disk.CDROM {
+			dev = DiskTarget("sd", sd)
+			sd++
+		} else {
+			dev = DiskTarget("vd", vd)
+			vd++
+		}
+		def.Devices.Disk = append(def.Devices.Disk, disk.Def(dev))
+	}
+
+	for _, nic := range nics {
+		def.Devices.Interface = append(def.Devices.Interface, nic.Def())
+	}
+
+	def.Devices.Serial.Type = "pty"
+	def.Devices.Console.Type = "pty"
+	def.Devices.Console.Target.Type = "serial"
+	def.Devices.Channel.Type = "unix"
+	def.Devices.Channel.Target.Type = "virtio"
+	def.Devices.Channel.Target.Name = "org.qemu.guest_agent.0"
+	def.Devices.Input.Type = "tablet"
+	def.Devices.Input.Bus = "usb"
+
+	// VNC with a websocket, the websocket port is what the console route proxies to.
+	// It's unauthenticated, so it's only reachable through the proxy.
+	g := DomDefGraphics{Type: "vnc", Port: "-1", Autoport: "yes", Websocket: "-1"}
+	g.Listen.Type = "address"
+	g.Listen.Address = GraphicsListen
+	def.Devices.Graphics = append(def.Devices.Graphics, g)
+
+	def.Devices.Video.Model.Type = "virtio"
+	def.Devices.Memballoon.Model = "virtio"
+	def.Devices.Rng.Model = "virtio"
+	def.Devices.Rng.Backend.Model = "random"
+	def.Devices.Rng.Backend.Text = "/dev/urandom"
+
+	return
+}
+
+// Disk definition of the disk at target dev, cdroms go on sata and the
+// rest on virtio
+func (disk DomDisk) Def(dev string) DomDefDisk {
+	d := DomDefDisk{Type: "file", Device: "disk"}
+	d.Driver.Name = "qemu"
+	d.Driver.Type = disk.Format
+	if disk.Block {
+		d.Type = "block"
+		d.Source.Dev = disk.Path
+	} else {
+		d.Source.File = disk.Path
+	}
+	d.Target.Dev = dev
+	if disk.CDROM {
+		d.Device = "cdrom"
+		d.Target.Bus = "sata"
+		d.Readonly = &struct{}{}
+	} else {
+		d.Target.Bus = "virtio"
+	}
+	if d.Driver.Type == "" {
+		d.Driver.Type = "raw"
+	}
+	return d
+}
+
+// Interface definition of the NIC, virtio unless another model is asked for
+func (nic DomNic) Def() DomDefInterface {
+	i := DomDefInterface{Type: "bridge"}
+	if nic.MAC != "" {
+		i.Mac = &DomDefMac{nic.MAC}
+	}
+	i.Source.Bridge = nic.Bridge
+	i.Model.Type = nic.Model
+	if i.Model.Type == "" {
+		i.Model.Type = "virtio"
+	}
+
+	if nic.OVS {
+		i.Virtualport = &DomDefVirtualport{Type: "openvswitch"}
+	}
+	switch {
+	case len(nic.Trunk) > 0:
+		i.Vlan = &DomDefVlan{Trunk: "yes"}
+		if nic.VLAN != 0 {
+			i.Vlan.Tag = append(i.Vlan.Tag, DomDefVlanTag{ID: nic.VLAN, NativeMode: "untagged"})
+		}
+		for _, tag := range nic.Trunk {
+			i.Vlan.Tag = append(i.Vlan.Tag, DomDefVlanTag{ID: tag})
+		}
+	case nic.VLAN != 0:
+		i.Vlan = &DomDefVlan{Tag: []DomDefVlanTag{{ID: nic.VLAN}}}
+	}
+
+	if nic.Queues > 1 {
+		i.Driver = &DomDefNicDriver{Name: "vhost", Queues: nic.Queues}
+	}
+
+	in, out := nic.Bandwidth.Inbound.def(), nic.Bandwidth.Outbound.def()
+	if in != nil || out != nil {
+		i.Bandwidth = &DomDefBandwidth{Inbound: in, Outbound: out}
+	}
+	return i
+}
+
+func (b DomBandwidthLimit) def() *DomDefBandwidthLimit {
+	if b.Average == 0 {
+		return nil
+	}
+	return &DomDefBandwidthLimit{Average: b.Average, Peak: b.Peak, Burst: b.Burst}
+}
+
+// Renders the domain definition to the XML document libvirt expects
+func (def DomDef) XML() (string, error) {
+	b, err := xml.MarshalIndent(def, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal domain definition: %v", err)
+	}
+	return string(b), nil
+}
+
+// Device names in the order the guest sees them: vda..vdz, vdaa..
+func DiskTarget(prefix string, index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('a'+index%26)) + name
+		index = index/26 - 1
+	}
+	return prefix + name
+}
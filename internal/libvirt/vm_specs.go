@@ -127,6 +127,7 @@ type DomSpecs struct {
 			Source struct {
 				Text  string `xml:",chardata"`
 				File  string `xml:"file,attr"`
+				Dev   string `xml:"dev,attr"`
 				Index string `xml:"index,attr"`
 			} `xml:"source"`
 			BackingStore string `xml:"backingStore"`
@@ -181,17 +182,34 @@ type DomSpecs struct {
 				Port    string `xml:"port,attr"`
 			} `xml:"target"`
 		} `xml:"controller"`
-		Interface struct {
+		Interface []struct {
 			Text string `xml:",chardata"`
 			Type string `xml:"type,attr"`
 			Mac  struct {
 				Text    string `xml:",chardata"`
 				Address string `xml:"address,attr"`
 			} `xml:"mac"`
+			Link struct {
+				Text  string `xml:",chardata"`
+				State string `xml:"state,attr"`
+			} `xml:"link"`
 			Source struct {
 				Text   string `xml:",chardata"`
 				Bridge string `xml:"bridge,attr"`
 			} `xml:"source"`
+			Vlan struct {
+				Text  string `xml:",chardata"`
+				Trunk string `xml:"trunk,attr"`
+				Tag   []struct {
+					Text       string `xml:",chardata"`
+					ID         int    `xml:"id,attr"`
+					NativeMode string `xml:"nativeMode,attr"`
+				} `xml:"tag"`
+			} `xml:"vlan"`
+			Virtualport struct {
+				Text string `xml:",chardata"`
+				Type string `xml:"type,attr"`
+			} `xml:"virtualport"`
 			Target struct {
 				Text string `xml:",chardata"`
 				Dev  string `xml:"dev,attr"`
@@ -200,6 +218,16 @@ type DomSpecs struct {
 				Text string `xml:",chardata"`
 				Type string `xml:"type,attr"`
 			} `xml:"model"`
+			Driver struct {
+				Text   string `xml:",chardata"`
+				Name   string `xml:"name,attr"`
+				Queues int    `xml:"queues,attr"`
+			} `xml:"driver"`
+			Bandwidth struct {
+				Text     string        `xml:",chardata"`
+				Inbound  BandwidthSpec `xml:"inbound"`
+				Outbound BandwidthSpec `xml:"outbound"`
+			} `xml:"bandwidth"`
 			Alias struct {
 				Text string `xml:",chardata"`
 				Name string `xml:"name,attr"`
@@ -364,6 +392,27 @@ type DomSpecs struct {
 				Function string `xml:"function,attr"`
 			} `xml:"address"`
 		} `xml:"rng"`
+		Hostdev []struct {
+			Text   string `xml:",chardata"`
+			Mode   string `xml:"mode,attr"`
+			Type   string `xml:"type,attr"`
+			Source struct {
+				Text   string `xml:",chardata"`
+				Vendor struct {
+					Text string `xml:",chardata"`
+					ID   string `xml:"id,attr"`
+				} `xml:"vendor"`
+				Product struct {
+					Text string `xml:",chardata"`
+					ID   string `xml:"id,attr"`
+				} `xml:"product"`
+				Address struct {
+					Text   string `xml:",chardata"`
+					Bus    string `xml:"bus,attr"`
+					Device string `xml:"device,attr"`
+				} `xml:"address"`
+			} `xml:"source"`
+		} `xml:"hostdev"`
 	} `xml:"devices"`
 	Seclabel []struct {
 		Text       string `xml:",chardata"`
@@ -374,3 +423,10 @@ type DomSpecs struct {
 		Imagelabel string `xml:"imagelabel"`
 	} `xml:"seclabel"`
 }
+
+// NIC bandwidth limit in one direction, KiB/s and KiB for the burst
+type BandwidthSpec struct {
+	Average uint32 `xml:"average,attr"`
+	Peak    uint32 `xml:"peak,attr"`
+	Burst   uint32 `xml:"burst,attr"`
+}
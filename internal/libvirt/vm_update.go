@@ -0,0 +1,383 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/digitalocean/go-libvirt"
+)
+
+// Hotplugs/unplugs vcpus on a running domain, only works up to the maximum
+func (l Libvirt) SetVMLiveVcpus(dom Dom, vcpus int) error {
+	return l.conn.DomainSetVcpusFlags(dom.Dom, uint32(vcpus), uint32(libvirt.DomainVCPULive))
+}
+
+// Balloons a running domain to kib, only works up to the maximum memory
+func (l Libvirt) SetVMLiveMemory(dom Dom, kib uint64) error {
+	return l.conn.DomainSetMemoryFlags(dom.Dom, kib, uint32(libvirt.DomainMemLive))
+}
+
+// Sets the description of a running domain, the persistent one goes through
+// UpdateVMConfig
+func (l Libvirt) SetVMLiveDescription(dom Dom, desc string) error {
+	return l.conn.DomainSetMetadata(dom.Dom, int32(libvirt.DomainMetadataDescription), libvirt.OptString{desc}, nil, nil, libvirt.DomainAffectLive)
+}
+
+func (l Libvirt) SetVMAutostart(dom Dom, autostart bool) error {
+	var v int32
+	if autostart {
+		v = 1
+	}
+	return l.conn.DomainSetAutostart(dom.Dom, v)
+}
+
+func (l Libvirt) GetVMAutostart(dom Dom) (bool, error) {
+	v, err := l.conn.DomainGetAutostart(dom.Dom)
+	return v == 1, err
+}
+
+// Replaces the bandwidth limits of the NIC with the MAC, live as well when
+// the domain is running. Zero averages lift the limits.
+func (l Libvirt) SetVMNicBandwidth(dom Dom, mac string, bw DomBandwidth, live bool) error {
+	flags := libvirt.DomainAffectConfig
+	if live {
+		flags |= libvirt.DomainAffectLive
+	}
+
+	var params []libvirt.TypedParam
+	for _, dir := range []struct {
+		name  string
+		limit DomBandwidthLimit
+	}{
+		{"inbound", bw.Inbound},
+		{"outbound", bw.Outbound},
+	} {
+		params = append(params,
+			libvirt.TypedParam{Field: dir.name + ".average", Value: *libvirt.NewTypedParamValueUint(dir.limit.Average)},
+			libvirt.TypedParam{Field: dir.name + ".peak", Value: *libvirt.NewTypedParamValueUint(dir.limit.Peak)},
+			libvirt.TypedParam{Field: dir.name + ".burst", Value: *libvirt.NewTypedParamValueUint(dir.limit.Burst)},
+		)
+	}
+
+	return l.conn.DomainSetInterfaceParameters(dom.Dom, mac, params, uint32(flags))
+}
+
+// Tells qemu a disk of a running domain grew to size bytes, qcow2 files are
+// grown by qemu itself while block devices have to be grown beforehand
+func (l Libvirt) ResizeVMDisk(dom Dom, dev string, size uint64) error {
+	return l.conn.DomainBlockResize(dom.Dom, dev, size, libvirt.DomainBlockResizeBytes)
+}
+
+// Changes to the persistent config of a domain, nil fields are left alone.
+// Memory sizes are in KiB.
+type DomConfigUpdate struct {
+	Vcpus       *int
+	Memory      *uint64
+	MaxMemory   *uint64
+	Description *string
+	Boot        []string
+}
+
+// Applies the changes to the persistent config in one redefinition, so
+// either all of them make it or none do
+func (l Libvirt) UpdateVMConfig(dom Dom, u DomConfigUpdate) error {
+	domXml, err := l.conn.DomainGetXMLDesc(dom.Dom, libvirt.DomainXMLInactive|libvirt.DomainXMLSecure)
+	if err != nil {
+		return err
+	}
+
+	domXml, err = UpdateDomainXML(domXml, u)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.conn.DomainDefineXML(domXml); err != nil {
+		return fmt.Errorf("failed to redefine domain: %v", err)
+	}
+	return nil
+}
+
+// Element of the domain XML as a generic tree, so the elements that get
+// changed keep whatever the definition structs don't know about
+type xmlElem struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []xmlElem  `xml:",any"`
+}
+
+func (e *xmlElem) attr(name string) string {
+	for _, a := range e.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Replaces every attribute with the name, or drops them on an empty value
+func (e *xmlElem) setAttr(name string, value string) {
+	attrs := e.Attrs[:0]
+	for _, a := range e.Attrs {
+		if a.Name.Local != name {
+			attrs = append(attrs, a)
+		}
+	}
+	if value != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+	}
+	e.Attrs = attrs
+}
+
+// Drops the indentation between child elements, it would be written out
+// before them
+func (e *xmlElem) trim() {
+	if len(e.Children) != 0 && strings.TrimSpace(e.Text) == "" {
+		e.Text = ""
+	}
+	for i := range e.Children {
+		e.Children[i].trim()
+	}
+}
+
+type xmlSpan struct {
+	start int
+	end   int
+}
+
+// Byte spans of the top level elements of the domain XML, by name, and the
+// offset of the closing domain tag
+func domainElements(domXml string) (map[string]xmlSpan, int, error) {
+	d := xml.NewDecoder(strings.NewReader(domXml))
+	elems := make(map[string]xmlSpan)
+	depth := 0
+	for {
+		off := int(d.InputOffset())
+		tok, err := d.Token()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse domain XML: %v", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 1 {
+				if err := d.Skip(); err != nil {
+					return nil, 0, fmt.Errorf("failed to parse domain XML: %v", err)
+				}
+				elems[t.Name.Local] = xmlSpan{off, int(d.InputOffset())}
+				continue
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				return elems, off, nil
+			}
+		}
+	}
+}
+
+// Sizes of the memory elements, in KiB
+func memoryKiB(e xmlElem) (uint64, error) {
+	v, err := strconv.ParseUint(strings.TrimSpace(e.Text), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", e.XMLName.Local, err)
+	}
+	switch e.attr("unit") {
+	case "b", "bytes":
+		return v / 1024, nil
+	case "", "k", "KiB":
+		return v, nil
+	case "M", "MiB":
+		return v * 1024, nil
+	case "G", "GiB":
+		return v * 1024 * 1024, nil
+	}
+	return 0, fmt.Errorf("unsupported %s unit: %s", e.XMLName.Local, e.attr("unit"))
+}
+
+var ErrMemoryOverMax = errors.New("memory can't be larger than the max memory")
+
+// Applies the changes to a domain XML. Only the elements being changed are
+// parsed and written back, everything else is kept byte for byte.
+func UpdateDomainXML(domXml string, u DomConfigUpdate) (string, error) {
+	spans, domEnd, err := domainElements(domXml)
+	if err != nil {
+		return "", err
+	}
+
+	elem := func(name string) (xmlElem, bool, error) {
+		e := xmlElem{XMLName: xml.Name{Local: name}}
+		span, ok := spans[name]
+		if !ok {
+			return e, false, nil
+		}
+		if err := xml.Unmarshal([]byte(domXml[span.start:span.end]), &e); err != nil {
+			return e, true, fmt.Errorf("failed to parse %s: %v", name, err)
+		}
+		return e, true, nil
+	}
+
+	// Replacement text by span, missing elements are added at the end
+	type edit struct {
+		span xmlSpan
+		text string
+	}
+	var edits []edit
+	put := func(e xmlElem) error {
+		e.trim()
+		// Indented as a child of domain, the first line already is
+		b, err := xml.MarshalIndent(e, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		text := strings.TrimPrefix(string(b), "  ")
+		span, ok := spans[e.XMLName.Local]
+		if !ok {
+			edits = append(edits, edit{xmlSpan{domEnd, domEnd}, "  " + text + "\n"})
+			return nil
+		}
+		edits = append(edits, edit{span, text})
+		return nil
+	}
+
+	if u.Vcpus != nil {
+		e, _, err := elem("vcpu")
+		if err != nil {
+			return "", err
+		}
+		// All of them, like a new domain
+		e.Text = strconv.Itoa(*u.Vcpus)
+		e.setAttr("current", "")
+		if err := put(e); err != nil {
+			return "", err
+		}
+	}
+
+	if u.Memory != nil || u.MaxMemory != nil {
+		maxElem, ok, err := elem("memory")
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", errors.New("domain has no memory element")
+		}
+		curElem, ok, err := elem("currentMemory")
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			curElem.Text = maxElem.Text
+			curElem.setAttr("unit", maxElem.attr("unit"))
+		}
+
+		max, err := memoryKiB(maxElem)
+		if err != nil {
+			return "", err
+		}
+		cur, err := memoryKiB(curElem)
+		if err != nil {
+			return "", err
+		}
+
+		if u.MaxMemory != nil {
+			max = *u.MaxMemory
+		}
+		if u.Memory != nil {
+			if *u.Memory > max {
+				return "", ErrMemoryOverMax
+			}
+			cur = *u.Memory
+		} else if cur > max {
+			// Lowering the maximum takes the memory down with it
+			cur = max
+		}
+
+		for _, m := range []struct {
+			e   xmlElem
+			kib uint64
+		}{
+			{maxElem, max},
+			{curElem, cur},
+		} {
+			m.e.Text = strconv.FormatUint(m.kib, 10)
+			m.e.setAttr("unit", "KiB")
+			if err := put(m.e); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if u.Description != nil {
+		e, ok, err := elem("description")
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case *u.Description != "":
+			e.Text = *u.Description
+			if err := put(e); err != nil {
+				return "", err
+			}
+		case ok:
+			edits = append(edits, edit{spans["description"], ""})
+		}
+	}
+
+	if len(u.Boot) != 0 {
+		e, ok, err := elem("os")
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", errors.New("domain has no os element")
+		}
+		children := e.Children[:0]
+		for _, c := range e.Children {
+			if c.XMLName.Local != "boot" {
+				children = append(children, c)
+			}
+		}
+		for _, dev := range u.Boot {
+			children = append(children, xmlElem{
+				XMLName: xml.Name{Local: "boot"},
+				Attrs:   []xml.Attr{{Name: xml.Name{Local: "dev"}, Value: dev}},
+			})
+		}
+		e.Children = children
+		if err := put(e); err != nil {
+			return "", err
+		}
+	}
+
+	// From the end so the spans stay valid
+	sort.SliceStable(edits, func(i, k int) bool {
+		return edits[i].span.start > edits[k].span.start
+	})
+	for _, e := range edits {
+		domXml = domXml[:e.span.start] + e.text + domXml[e.span.end:]
+	}
+	return domXml, nil
+}
@@ -1,13 +1,54 @@
 package routes
 
 import (
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/BasedDevelopment/auto/internal/controllers"
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/util"
+	"github.com/BasedDevelopment/auto/pkg/models"
 	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
 )
 
+// Upgrader of the API listener. Callers there hold a client certificate, so
+// any origin is fine; browsers only come in through the console listener.
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Origins of the pages allowed to use console tokens, from the config
+var ConsoleOrigins []string
+
+// Upgrader of the console listener, a token alone doesn't let another page
+// open the console
+var consoleUpgrader = websocket.Upgrader{
+	CheckOrigin: consoleOrigin,
+}
+
+// Requests without an Origin don't come from a browser page
+func consoleOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range ConsoleOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
 func GetConsole(w http.ResponseWriter, r *http.Request) {
 	domain, err := getDomain(r)
 	if err != nil {
@@ -15,6 +56,11 @@ func GetConsole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	proxyVNC(w, r, domain)
+}
+
+// Proxies to the VNC websocket of the domain, which only listens on localhost
+func proxyVNC(w http.ResponseWriter, r *http.Request, domain *models.VM) {
 	port, err := HV.GetVMConsole(domain)
 	if err != nil {
 		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get console port")
@@ -28,3 +74,181 @@ func GetConsole(w http.ResponseWriter, r *http.Request) {
 	proxy := httputil.NewSingleHostReverseProxy(wsUrl)
 	proxy.ServeHTTP(w, r)
 }
+
+// Bridges the serial console to a websocket. Console output is sent as
+// binary messages, input is taken from binary and text messages alike so
+// xterm.js' onData can be sent as is. Text messages from the server are
+// notices, a read-only console (see VMSerial.Writable) says so as soon as
+// it's opened and its input is ignored.
+func GetSerialConsole(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	serveSerial(w, r, domain, &upgrader)
+}
+
+func serveSerial(w http.ResponseWriter, r *http.Request, domain *models.VM, upgrader *websocket.Upgrader) {
+	session, err := HV.OpenSerialConsole(domain)
+	if err != nil {
+		switch {
+		case errors.Is(err, libvirt.ErrNoSerial):
+			eUtil.WriteError(w, r, err, http.StatusNotFound, "Domain has no serial console")
+		case errors.Is(err, controllers.ErrDomainNotRunning):
+			eUtil.WriteError(w, r, err, http.StatusConflict, "Domain is not running")
+		default:
+			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to open serial console")
+		}
+		return
+	}
+	defer session.Close()
+
+	// Upgrade writes the error response itself
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	bridgeSerial(conn, session, domain.ID.String())
+}
+
+// What bridgeSerial needs of a controllers.SerialSession
+type serialSession interface {
+	Stream(out io.Writer) error
+	Input() io.Writer
+	Close() error
+}
+
+// Sent when the console can't take input, libvirtd isn't on this host
+const serialReadOnly = "console is read-only"
+
+// Runs until either side goes away. The session is closed on the way out so
+// the stream doesn't outlive the websocket.
+func bridgeSerial(conn *websocket.Conn, session serialSession, domain string) {
+	out := &wsWriter{conn: conn}
+	in := session.Input()
+	if in == nil {
+		out.notice(serialReadOnly)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := session.Stream(out)
+		if err != nil {
+			log.Debug().Err(err).Str("domain", domain).Msg("serial console stream ended")
+		}
+		out.close("console closed")
+	}()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		if in == nil {
+			continue
+		}
+		if _, err := in.Write(msg); err != nil {
+			break
+		}
+	}
+	out.close("")
+	session.Close()
+	<-done
+}
+
+// Issues a single-use token for the console listener, so eve can hand out
+// console URLs without a client certificate
+func CreateConsoleToken(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	req := new(util.ConsoleTokenRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	token, err := HV.IssueConsoleToken(domain, req.Type)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to issue console token")
+		return
+	}
+
+	if err := eUtil.WriteResponse(token, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+// Redeems a console token on the console listener
+func RedeemConsoleToken(w http.ResponseWriter, r *http.Request) {
+	// Checked before the token is spent, VNC is proxied without an upgrader
+	if !consoleOrigin(r) {
+		eUtil.WriteError(w, r, errors.New("origin not allowed"), http.StatusForbidden, "Origin not allowed")
+		return
+	}
+
+	domain, kind, err := HV.RedeemConsoleToken(chi.URLParam(r, "token"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusForbidden, "Invalid or expired console token")
+		return
+	}
+
+	switch kind {
+	case "vnc":
+		proxyVNC(w, r, domain)
+	case "serial":
+		serveSerial(w, r, domain, &consoleUpgrader)
+	}
+}
+
+// Console output to websocket messages, the console stream fails on the
+// first write after close
+type wsWriter struct {
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	closed bool
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if err := w.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Tells the client something about the console itself, as a text message
+func (w *wsWriter) notice(msg string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return io.ErrClosedPipe
+	}
+	return w.conn.WriteMessage(websocket.TextMessage, []byte(msg))
+}
+
+func (w *wsWriter) close(reason string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+	w.closed = true
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+	w.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
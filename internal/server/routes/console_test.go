@@ -0,0 +1,168 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package routes
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Serial session whose stream, like libvirt's, only ends when closed
+type fakeSerial struct {
+	mu       sync.Mutex
+	in       bytes.Buffer
+	closed   chan struct{}
+	once     sync.Once
+	streamed chan struct{}
+}
+
+func newFakeSerial() *fakeSerial {
+	return &fakeSerial{closed: make(chan struct{}), streamed: make(chan struct{})}
+}
+
+func (s *fakeSerial) Stream(out io.Writer) error {
+	defer close(s.streamed)
+	if _, err := out.Write([]byte("login: ")); err != nil {
+		return err
+	}
+	<-s.closed
+	return io.EOF
+}
+
+func (s *fakeSerial) Input() io.Writer { return s }
+
+func (s *fakeSerial) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.in.Write(p)
+}
+
+func (s *fakeSerial) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func TestBridgeSerialClosesStream(t *testing.T) {
+	session := newFakeSerial()
+	bridged := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bridgeSerial(conn, session, "test")
+		close(bridged)
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err := conn.ReadMessage()
+	if err != nil || string(msg) != "login: " {
+		t.Fatalf("got %q, %v", msg, err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("root\n")); err != nil {
+		t.Fatal(err)
+	}
+	// Gone without a close handshake, like a dropped client
+	conn.Close()
+
+	for name, ch := range map[string]chan struct{}{"stream": session.streamed, "bridge": bridged} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s still running after the websocket closed", name)
+		}
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if got := session.in.String(); got != "root\n" {
+		t.Errorf("got input %q", got)
+	}
+}
+
+// The same session without input, like a console on a remote libvirtd
+type readOnlySerial struct{ *fakeSerial }
+
+func (readOnlySerial) Input() io.Writer { return nil }
+
+func TestBridgeSerialReadOnly(t *testing.T) {
+	session := readOnlySerial{newFakeSerial()}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bridgeSerial(conn, session, "test")
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	kind, msg, err := conn.ReadMessage()
+	if err != nil || kind != websocket.TextMessage || string(msg) != serialReadOnly {
+		t.Fatalf("got %d %q, %v, want the read-only notice first", kind, msg, err)
+	}
+	kind, msg, err = conn.ReadMessage()
+	if err != nil || kind != websocket.BinaryMessage || string(msg) != "login: " {
+		t.Fatalf("got %d %q, %v", kind, msg, err)
+	}
+}
+
+func TestConsoleOrigin(t *testing.T) {
+	saved := ConsoleOrigins
+	ConsoleOrigins = []string{"https://eve.example.com", "https://panel.example.com:8443/"}
+	defer func() { ConsoleOrigins = saved }()
+
+	for origin, want := range map[string]bool{
+		"":                                true,
+		"https://eve.example.com":         true,
+		"https://EVE.example.com":         true,
+		"https://panel.example.com:8443":  true,
+		"http://eve.example.com":          false,
+		"https://eve.example.com.evil.io": false,
+		"https://panel.example.com":       false,
+		"null":                            false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/console/token", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if got := consoleOrigin(r); got != want {
+			t.Errorf("%q: got %v, want %v", origin, got, want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"net/http"
 
+	"github.com/BasedDevelopment/auto/internal/controllers"
 	"github.com/BasedDevelopment/auto/internal/util"
 	eUtil "github.com/BasedDevelopment/eve/pkg/util"
 	"github.com/go-chi/chi/v5"
@@ -22,13 +23,25 @@ func CreateDomain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := HV.CreateDomain(domID, req); err != nil {
-		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to create domain")
+	for _, iface := range req.Iface {
+		if err := controllers.CheckIface(iface); err != nil {
+			eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid iface")
+			return
+		}
+	}
+
+	if _, err := getDomain(r); err == nil {
+		eUtil.WriteError(w, r, controllers.ErrDomainExists, http.StatusConflict, "Domain already exists")
 		return
 	}
 
-	if err := eUtil.WriteResponse("", w, http.StatusCreated); err != nil {
-		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	job, err := HV.StartJob("create_domain", domID, func(progress controllers.JobProgress) (interface{}, error) {
+		return nil, HV.CreateDomain(domID, req, progress)
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusConflict, "Domain is busy")
 		return
 	}
+
+	writeJob(w, r, job)
 }
@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/BasedDevelopment/auto/internal/controllers"
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+)
+
+// Status code for a failed disk operation
+func diskErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, controllers.ErrDiskNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, controllers.ErrDiskShrink):
+		return http.StatusBadRequest
+	case errors.Is(err, controllers.ErrNoSpace):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, controllers.ErrStorageNotFound):
+		return http.StatusBadRequest
+	case errors.Is(err, controllers.ErrDomainBusy):
+		return http.StatusConflict
+	case errors.Is(err, libvirt.ErrDetachTimeout):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+func ResizeDisk(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	req := new(util.DiskResizeRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	disk, err := HV.ResizeDisk(domain, chi.URLParam(r, "disk"), req.Size)
+	if err != nil {
+		eUtil.WriteError(w, r, err, diskErrorStatus(err), "Failed to resize disk")
+		return
+	}
+
+	if err := eUtil.WriteResponse(disk, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func AttachDisk(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	req := new(util.DiskAttachRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	disk, err := HV.AttachDisk(domain, req)
+	if err != nil {
+		eUtil.WriteError(w, r, err, diskErrorStatus(err), "Failed to attach disk")
+		return
+	}
+
+	if err := eUtil.WriteResponse(disk, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DetachDisk(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	// The volume is kept unless asked otherwise
+	storage := r.URL.Query().Get("storage")
+	switch storage {
+	case "", controllers.DeleteStorageKeep, controllers.DeleteStorageDisks:
+	default:
+		eUtil.WriteError(w, r, fmt.Errorf("invalid storage option: %s", storage), http.StatusBadRequest, "storage must be one of keep or disks")
+		return
+	}
+
+	res, err := HV.DetachDisk(domain, chi.URLParam(r, "disk"), storage)
+	if err != nil {
+		eUtil.WriteError(w, r, err, diskErrorStatus(err), "Failed to detach disk")
+		return
+	}
+
+	if err := eUtil.WriteResponse(res, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
@@ -1,10 +1,13 @@
 package routes
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/BasedDevelopment/auto/internal/controllers"
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/util"
 	"github.com/BasedDevelopment/auto/pkg/models"
 	eUtil "github.com/BasedDevelopment/eve/pkg/util"
 	"github.com/go-chi/chi/v5"
@@ -14,11 +17,7 @@ import (
 var HV = controllers.Hypervisor
 
 func GetDomains(w http.ResponseWriter, r *http.Request) {
-	var resp []models.VM
-	for _, vm := range controllers.Hypervisor.VMs {
-		resp = append(resp, *vm)
-	}
-	if err := eUtil.WriteResponse(resp, w, http.StatusOK); err != nil {
+	if err := eUtil.WriteResponse(HV.ListVMs(), w, http.StatusOK); err != nil {
 		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
 	}
 }
@@ -31,7 +30,7 @@ func getDomain(r *http.Request) (*models.VM, error) {
 		return nil, err
 	}
 
-	vm, ok := HV.VMs[domid]
+	vm, ok := HV.LookupVM(domid)
 	if !ok {
 		return nil, fmt.Errorf("Domain not found")
 	}
@@ -51,42 +50,63 @@ func GetDomain(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func DeleteDomain(w http.ResponseWriter, r *http.Request) {
-	domidStr := chi.URLParam(r, "domain")
-	domid, _ := uuid.Parse(domidStr)
-	resp := map[string]interface{}{
-		"domain": domid,
+func UpdateDomain(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
 	}
-	if err := eUtil.WriteResponse(resp, w, http.StatusOK); err != nil {
-		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+
+	req := new(util.DomainUpdateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
 	}
 
-	/*
-		domain, err := getDomain(r)
-		if err != nil {
-			eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+	unlock, err := HV.LockDomain(domain.ID, "update_domain")
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusConflict, "Domain is busy")
+		return
+	}
+	defer unlock()
+
+	res, err := HV.UpdateDomain(domain, req)
+	if err != nil {
+		if errors.Is(err, libvirt.ErrMemoryOverMax) {
+			eUtil.WriteError(w, r, err, http.StatusBadRequest, err.Error())
 			return
 		}
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to update domain")
+		return
+	}
 
-		for _, disk := range domain.Storages {
-			if err := HV.DeleteDiskFile(disk.Path); err != nil {
-				eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to delete disk")
-				return
-			}
-		}
+	if err := eUtil.WriteResponse(res, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
 
-		if err := HV.DestroyVM(domain); err != nil {
-			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to destroy domain")
-			return
-		}
+func DeleteDomain(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
 
-		if err := HV.UndefineVM(domain); err != nil {
-			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to undefine domain")
-			return
-		}
+	storage := r.URL.Query().Get("storage")
+	switch storage {
+	case "", controllers.DeleteStorageKeep, controllers.DeleteStorageDisks, controllers.DeleteStorageAll:
+	default:
+		eUtil.WriteError(w, r, fmt.Errorf("invalid storage option: %s", storage), http.StatusBadRequest, "storage must be one of keep, disks or all")
+		return
+	}
 
-		if err := eUtil.WriteResponse(domain, w, http.StatusOK); err != nil {
-			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
-		}
-	*/
+	job, err := HV.StartJob("delete_domain", domain.ID, func(progress controllers.JobProgress) (interface{}, error) {
+		return HV.DeleteDomain(domain, storage)
+	})
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusConflict, "Domain is busy")
+		return
+	}
+
+	writeJob(w, r, job)
 }
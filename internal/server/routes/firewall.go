@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/auto/internal/controllers"
+	"github.com/BasedDevelopment/auto/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+)
+
+func GetFirewall(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	rules, err := HV.GetFirewall(domain)
+	if errors.Is(err, controllers.ErrFirewallDisabled) {
+		eUtil.WriteError(w, r, err, http.StatusNotImplemented, "Firewall is not enabled")
+		return
+	}
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get firewall")
+		return
+	}
+
+	if err := eUtil.WriteResponse(rules, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func SetFirewall(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	req := new(util.FirewallRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	rules, err := HV.SetFirewall(domain, req)
+	if errors.Is(err, controllers.ErrFirewallDisabled) {
+		eUtil.WriteError(w, r, err, http.StatusNotImplemented, "Firewall is not enabled")
+		return
+	}
+	if errors.Is(err, controllers.ErrInvalidFirewall) {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid firewall rules")
+		return
+	}
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to set firewall")
+		return
+	}
+
+	if err := eUtil.WriteResponse(rules, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
@@ -0,0 +1,42 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/BasedDevelopment/auto/pkg/models"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Accepted response for operations running as a job, poll Location for the outcome
+func writeJob(w http.ResponseWriter, r *http.Request, job models.Job) {
+	w.Header().Set("Location", "/jobs/"+job.ID.String())
+	if err := eUtil.WriteResponse(job, w, http.StatusAccepted); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetJobs(w http.ResponseWriter, r *http.Request) {
+	if err := eUtil.WriteResponse(HV.GetJobs(), w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(chi.URLParam(r, "job"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "invalid job id")
+		return
+	}
+
+	job, err := HV.GetJob(jobID)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	if err := eUtil.WriteResponse(job, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
@@ -0,0 +1,85 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/auto/internal/controllers"
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+)
+
+func MigrateDomain(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	req := new(util.DomainMigrateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	job, err := HV.MigrateDomain(domain, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, controllers.ErrPeerNotFound):
+			eUtil.WriteError(w, r, err, http.StatusBadRequest, "Unknown peer")
+		case errors.Is(err, libvirt.ErrOfflineCopyStorage):
+			eUtil.WriteError(w, r, err, http.StatusBadRequest, "Storage can't be copied while the domain is shut off")
+		case errors.Is(err, controllers.ErrOfflineNotShared):
+			eUtil.WriteError(w, r, err, http.StatusBadRequest, "Peer doesn't share storage, start the domain to migrate it")
+		case errors.Is(err, controllers.ErrDomainBusy):
+			eUtil.WriteError(w, r, err, http.StatusConflict, "Domain is busy")
+		default:
+			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to start migration")
+		}
+		return
+	}
+
+	writeJob(w, r, job)
+}
+
+func GetMigration(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	migration, err := HV.GetMigration(domain)
+	if err != nil {
+		if errors.Is(err, controllers.ErrNotMigrating) {
+			eUtil.WriteError(w, r, err, http.StatusNotFound, "Domain is not being migrated")
+			return
+		}
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get migration")
+		return
+	}
+
+	if err := eUtil.WriteResponse(migration, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func CancelMigration(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	if err := HV.CancelMigration(domain); err != nil {
+		if errors.Is(err, controllers.ErrNotMigrating) {
+			eUtil.WriteError(w, r, err, http.StatusNotFound, "Domain is not being migrated")
+			return
+		}
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to cancel migration")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
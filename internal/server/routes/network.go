@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/auto/internal/controllers"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+)
+
+func GetNetworks(w http.ResponseWriter, r *http.Request) {
+	networks, err := HV.GetNetworks()
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get networks")
+		return
+	}
+
+	if err := eUtil.WriteResponse(networks, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetNetwork(w http.ResponseWriter, r *http.Request) {
+	network, err := HV.GetNetwork(chi.URLParam(r, "network"))
+	if err != nil {
+		if errors.Is(err, controllers.ErrNetworkNotFound) {
+			eUtil.WriteError(w, r, err, http.StatusNotFound, "Network not found")
+			return
+		}
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get network")
+		return
+	}
+
+	if err := eUtil.WriteResponse(network, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/auto/internal/controllers"
+	"github.com/BasedDevelopment/auto/internal/libvirt"
+	"github.com/BasedDevelopment/auto/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+)
+
+// Status code for a failed NIC operation
+func nicErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, controllers.ErrNicNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, controllers.ErrUnknownBridge), errors.Is(err, controllers.ErrVLANNeedsOVS):
+		return http.StatusBadRequest
+	case errors.Is(err, controllers.ErrDomainBusy), errors.Is(err, controllers.ErrNicExists):
+		return http.StatusConflict
+	case errors.Is(err, libvirt.ErrDetachTimeout):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+func AttachNic(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	req := new(util.IfaceRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	nic, err := HV.AttachNic(domain, req)
+	if err != nil {
+		eUtil.WriteError(w, r, err, nicErrorStatus(err), "Failed to attach nic")
+		return
+	}
+
+	if err := eUtil.WriteResponse(nic, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DetachNic(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	if err := HV.DetachNic(domain, chi.URLParam(r, "nic")); err != nil {
+		eUtil.WriteError(w, r, err, nicErrorStatus(err), "Failed to detach nic")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func UpdateNic(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	req := new(util.NicUpdateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	nic, err := HV.UpdateNic(domain, chi.URLParam(r, "nic"), req)
+	if err != nil {
+		eUtil.WriteError(w, r, err, nicErrorStatus(err), "Failed to update nic")
+		return
+	}
+
+	if err := eUtil.WriteResponse(nic, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
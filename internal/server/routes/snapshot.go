@@ -0,0 +1,123 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/BasedDevelopment/auto/internal/controllers"
+	"github.com/BasedDevelopment/auto/internal/util"
+	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+)
+
+// Status code for a failed snapshot operation
+func snapshotErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, controllers.ErrSnapshotNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, controllers.ErrDomainBusy):
+		return http.StatusConflict
+	case errors.Is(err, controllers.ErrSnapshotMixedDisks),
+		errors.Is(err, controllers.ErrSnapshotExternal):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func GetSnapshots(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	snaps, err := HV.GetSnapshots(domain)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get snapshots")
+		return
+	}
+
+	if err := eUtil.WriteResponse(snaps, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	snap, err := HV.GetSnapshot(domain, chi.URLParam(r, "snapshot"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, snapshotErrorStatus(err), "Failed to get snapshot")
+		return
+	}
+
+	if err := eUtil.WriteResponse(snap, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func CreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	req := new(util.SnapshotCreateRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	snap, err := HV.CreateSnapshot(domain, req)
+	if err != nil {
+		eUtil.WriteError(w, r, err, snapshotErrorStatus(err), "Failed to create snapshot")
+		return
+	}
+
+	if err := eUtil.WriteResponse(snap, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func RevertSnapshot(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	if err := HV.RevertSnapshot(domain, chi.URLParam(r, "snapshot")); err != nil {
+		eUtil.WriteError(w, r, err, snapshotErrorStatus(err), "Failed to revert to snapshot")
+		return
+	}
+
+	state, err := HV.GetVMState(domain)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get domain state")
+		return
+	}
+
+	if err := eUtil.WriteResponse(state, w, http.StatusOK); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func DeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	domain, err := getDomain(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Invalid domain ID or can't be found")
+		return
+	}
+
+	if err := HV.DeleteSnapshot(domain, chi.URLParam(r, "snapshot")); err != nil {
+		eUtil.WriteError(w, r, err, snapshotErrorStatus(err), "Failed to delete snapshot")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
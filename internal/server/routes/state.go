@@ -1,12 +1,26 @@
 package routes
 
 import (
+	"errors"
 	"net/http"
 
+	"github.com/BasedDevelopment/auto/internal/controllers"
 	"github.com/BasedDevelopment/auto/internal/util"
 	eUtil "github.com/BasedDevelopment/eve/pkg/util"
 )
 
+// Status code for a failed state action
+func stateErrorStatus(err error) int {
+	var te *controllers.TransitionError
+	switch {
+	case errors.As(err, &te):
+		return http.StatusConflict
+	case errors.Is(err, controllers.ErrDomainBusy):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
 func GetDomainState(w http.ResponseWriter, r *http.Request) {
 	domain, err := getDomain(r)
 	if err != nil {
@@ -38,37 +52,20 @@ func SetDomainState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	switch req.State {
-	case "start":
-		if err := HV.Libvirt.VMStart(domain.Domain); err != nil {
-			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to start VM")
-			return
-		}
-	case "reboot":
-		if err := HV.Libvirt.VMReboot(domain.Domain); err != nil {
-			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "failed to reboot virtual machine")
-			return
-		}
-	case "poweroff":
-		if err := HV.Libvirt.VMPowerOff(domain.Domain); err != nil {
-			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "failed to power off virtual machine")
-			return
-		}
-	case "stop":
-		if err := HV.Libvirt.VMStop(domain.Domain); err != nil {
-			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "failed to stop virtual machine")
-			return
-		}
-	case "reset":
-		if err := HV.Libvirt.VMReset(domain.Domain); err != nil {
-			eUtil.WriteError(w, r, err, http.StatusInternalServerError, "failed to reset virtual machine")
-			return
+	state, job, err := HV.SetVMState(domain, req)
+	if err != nil {
+		msg := "Failed to set domain state"
+		var te *controllers.TransitionError
+		if errors.As(err, &te) {
+			msg = te.Error()
 		}
+		eUtil.WriteError(w, r, err, stateErrorStatus(err), msg)
+		return
 	}
 
-	state, err := HV.GetVMState(domain)
-	if err != nil {
-		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to get domain state")
+	// Waiting for a guest to shut down goes on in the background
+	if job != nil {
+		writeJob(w, r, *job)
 		return
 	}
 
@@ -0,0 +1,44 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/BasedDevelopment/auto/internal/controllers"
+)
+
+func TestStateErrorStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{&controllers.TransitionError{Action: "start", State: "Running"}, http.StatusConflict},
+		{fmt.Errorf("wrapped: %w", &controllers.TransitionError{Action: "resume", State: "Shutoff"}), http.StatusConflict},
+		{fmt.Errorf("%w: migrate in progress", controllers.ErrDomainBusy), http.StatusConflict},
+		{errors.New("failed to start domain"), http.StatusInternalServerError},
+	} {
+		if got := stateErrorStatus(tc.err); got != tc.want {
+			t.Errorf("%v: got %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
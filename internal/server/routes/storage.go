@@ -1,40 +1,182 @@
 package routes
 
 import (
+	"errors"
 	"net/http"
 
-	"github.com/BasedDevelopment/auto/internal/config"
 	"github.com/BasedDevelopment/auto/internal/controllers"
+	"github.com/BasedDevelopment/auto/internal/storage"
+	"github.com/BasedDevelopment/auto/internal/util"
+	"github.com/BasedDevelopment/auto/pkg/models"
 	eUtil "github.com/BasedDevelopment/eve/pkg/util"
+	"github.com/go-chi/chi/v5"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 )
 
+func getStorage(r *http.Request) (*models.HVStorage, error) {
+	return HV.GetStorage(chi.URLParam(r, "storage"))
+}
+
 func GetStorages(w http.ResponseWriter, r *http.Request) {
-	resp := config.Config.Storage
-	if err := eUtil.WriteResponse(resp, w, http.StatusOK); err != nil {
+	if err := eUtil.WriteResponse(HV.GetStorages(), w, http.StatusOK); err != nil {
 		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
 	}
 }
 
-func GetImages(w http.ResponseWriter, r *http.Request) {
-	//	storage := chi.URLParam(r, "storage")
-	// this will be a path, how will we represent this path in a url?
-	// OH IN THE CONFIG WE NAMED THE STORAGE, we will have to first convirt everything to storage name instead of path
-	resp := controllers.Images
-	if err := eUtil.WriteResponse(resp, w, http.StatusOK); err != nil {
+func GetStorage(w http.ResponseWriter, r *http.Request) {
+	storage, err := getStorage(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Storage not found")
+		return
+	}
+
+	if err := eUtil.WriteResponse(storage, w, http.StatusOK); err != nil {
 		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
 	}
 }
 
+func GetImages(w http.ResponseWriter, r *http.Request) {
+	listStorage(w, r, controllers.StorageImages)
+}
+
 func GetCloudImages(w http.ResponseWriter, r *http.Request) {
-	resp := controllers.Images
-	if err := eUtil.WriteResponse(resp, w, http.StatusOK); err != nil {
+	listStorage(w, r, controllers.StorageCloudImages)
+}
+
+func GetDisks(w http.ResponseWriter, r *http.Request) {
+	listStorage(w, r, controllers.StorageDisks)
+}
+
+func listStorage(w http.ResponseWriter, r *http.Request, content string) {
+	storage, err := getStorage(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Storage not found")
+		return
+	}
+
+	files, err := HV.ListStorage(storage, content)
+	if err != nil {
+		if errors.Is(err, controllers.ErrStorageNotFound) {
+			eUtil.WriteError(w, r, err, http.StatusNotFound, "Storage doesn't hold "+content)
+			return
+		}
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to list storage")
+		return
+	}
+
+	if err := eUtil.WriteResponse(files, w, http.StatusOK); err != nil {
 		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
 	}
 }
 
-func GetDisks(w http.ResponseWriter, r *http.Request) {
-	resp := controllers.Disks
-	if err := eUtil.WriteResponse(resp, w, http.StatusOK); err != nil {
+func UploadImage(w http.ResponseWriter, r *http.Request) {
+	uploadImage(w, r, controllers.StorageImages)
+}
+
+func UploadCloudImage(w http.ResponseWriter, r *http.Request) {
+	uploadImage(w, r, controllers.StorageCloudImages)
+}
+
+func FetchImage(w http.ResponseWriter, r *http.Request) {
+	fetchImage(w, r, controllers.StorageImages)
+}
+
+func FetchCloudImage(w http.ResponseWriter, r *http.Request) {
+	fetchImage(w, r, controllers.StorageCloudImages)
+}
+
+// Status code for a failed image upload or download
+func imageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, controllers.ErrStorageNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrImageExists), errors.Is(err, controllers.ErrFileBusy):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrChecksum):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// The body is the image itself, an optional sha256 query parameter is
+// checked before the image is put in place
+func uploadImage(w http.ResponseWriter, r *http.Request, content string) {
+	s, err := getStorage(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Storage not found")
+		return
+	}
+
+	name := chi.URLParam(r, "image")
+	if err := validation.Validate(name, util.ImageNameRules...); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Invalid image name")
+		return
+	}
+
+	file, err := HV.UploadImage(s, content, name, r.Body, r.URL.Query().Get("sha256"))
+	if err != nil {
+		eUtil.WriteError(w, r, err, imageErrorStatus(err), "Failed to upload image")
+		return
+	}
+
+	if err := eUtil.WriteResponse(file, w, http.StatusCreated); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
+	}
+}
+
+func fetchImage(w http.ResponseWriter, r *http.Request, content string) {
+	s, err := getStorage(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Storage not found")
+		return
+	}
+
+	req := new(util.ImageFetchRequest)
+	if err := util.ParseRequest(r, req); err != nil {
+		eUtil.WriteError(w, r, err, http.StatusBadRequest, "Failed to parse request")
+		return
+	}
+
+	job, err := HV.FetchImage(s, content, req)
+	if err != nil {
+		eUtil.WriteError(w, r, err, imageErrorStatus(err), "Failed to start image download")
+		return
+	}
+
+	writeJob(w, r, job)
+}
+
+func GetImage(w http.ResponseWriter, r *http.Request) {
+	inspectStorageFile(w, r, controllers.StorageImages, chi.URLParam(r, "image"))
+}
+
+func GetCloudImage(w http.ResponseWriter, r *http.Request) {
+	inspectStorageFile(w, r, controllers.StorageCloudImages, chi.URLParam(r, "image"))
+}
+
+// Disks are named <domain>/<disk>.qcow2
+func GetDisk(w http.ResponseWriter, r *http.Request) {
+	inspectStorageFile(w, r, controllers.StorageDisks, chi.URLParam(r, "*"))
+}
+
+func inspectStorageFile(w http.ResponseWriter, r *http.Request, content string, name string) {
+	s, err := getStorage(r)
+	if err != nil {
+		eUtil.WriteError(w, r, err, http.StatusNotFound, "Storage not found")
+		return
+	}
+
+	info, err := HV.InspectStorageFile(s, content, name)
+	if err != nil {
+		if errors.Is(err, controllers.ErrStorageNotFound) || errors.Is(err, controllers.ErrFileNotFound) {
+			eUtil.WriteError(w, r, err, http.StatusNotFound, "File not found")
+			return
+		}
+		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to inspect file")
+		return
+	}
+
+	if err := eUtil.WriteResponse(info, w, http.StatusOK); err != nil {
 		eUtil.WriteError(w, r, err, http.StatusInternalServerError, "Failed to marshall/send response")
 	}
 }
@@ -36,7 +36,8 @@ func Service() *chi.Mux {
 	r.Use(cm.RequestID)
 	r.Use(middleware.Logger)
 	r.Use(httprate.LimitByIP(100, 1*time.Minute))
-	r.Use(cm.AllowContentType("application/json"))
+	// Image uploads are the only raw bodies
+	r.Use(cm.AllowContentType("application/json", "application/octet-stream"))
 	r.Use(cm.CleanPath)
 	r.Use(cm.NoCache)
 	r.Use(cm.Heartbeat("/"))
@@ -46,29 +47,80 @@ func Service() *chi.Mux {
 		w.Write([]byte("pong"))
 	})
 
+	r.Route("/jobs", func(r chi.Router) {
+		r.Get("/", routes.GetJobs)
+		r.Get("/{job}", routes.GetJob)
+	})
+
 	r.Route("/libvirt", func(r chi.Router) {
 		r.Get("/", routes.GetHV)
 		r.Route("/storage", func(r chi.Router) {
 			r.Get("/", routes.GetStorages)
 			r.Route("/{storage}", func(r chi.Router) {
-				// this is fine, have it read from config, and make sure it is also valid by checking the slices
-				// then have a route return the stuff in each storage
-				r.Get("/images", routes.GetImages)
-				r.Get("/cloud-images", routes.GetCloudImages)
-				r.Get("/disks", routes.GetDisks)
+				r.Get("/", routes.GetStorage)
+				r.Route("/images", func(r chi.Router) {
+					r.Get("/", routes.GetImages)
+					r.Post("/", routes.FetchImage)
+					r.Get("/{image}", routes.GetImage)
+					r.Put("/{image}", routes.UploadImage)
+				})
+				r.Route("/cloud-images", func(r chi.Router) {
+					r.Get("/", routes.GetCloudImages)
+					r.Post("/", routes.FetchCloudImage)
+					r.Get("/{image}", routes.GetCloudImage)
+					r.Put("/{image}", routes.UploadCloudImage)
+				})
+				r.Route("/disks", func(r chi.Router) {
+					r.Get("/", routes.GetDisks)
+					r.Get("/*", routes.GetDisk)
+				})
 			})
 		})
+		r.Route("/networks", func(r chi.Router) {
+			r.Get("/", routes.GetNetworks)
+			r.Get("/{network}", routes.GetNetwork)
+		})
 		r.Route("/domains", func(r chi.Router) {
 			r.Get("/", routes.GetDomains)
 			r.Route("/{domain}", func(r chi.Router) {
 				r.Get("/", routes.GetDomain)
 				r.Put("/", routes.CreateDomain)
 				r.Get("/console", routes.GetConsole)
+				r.Get("/console/serial", routes.GetSerialConsole)
+				r.Post("/console/token", routes.CreateConsoleToken)
 				r.Route("/state", func(r chi.Router) {
 					r.Get("/", routes.GetDomainState)
 					r.Patch("/", routes.SetDomainState)
 				})
-				//r.Patch("/", routes.UpdateDomain)
+				r.Route("/snapshots", func(r chi.Router) {
+					r.Get("/", routes.GetSnapshots)
+					r.Post("/", routes.CreateSnapshot)
+					r.Route("/{snapshot}", func(r chi.Router) {
+						r.Get("/", routes.GetSnapshot)
+						r.Post("/revert", routes.RevertSnapshot)
+						r.Delete("/", routes.DeleteSnapshot)
+					})
+				})
+				r.Route("/migration", func(r chi.Router) {
+					r.Get("/", routes.GetMigration)
+					r.Post("/", routes.MigrateDomain)
+					r.Delete("/", routes.CancelMigration)
+				})
+				r.Route("/disks", func(r chi.Router) {
+					r.Post("/", routes.AttachDisk)
+					r.Delete("/{disk}", routes.DetachDisk)
+					r.Post("/{disk}/resize", routes.ResizeDisk)
+				})
+				r.Route("/nics", func(r chi.Router) {
+					r.Post("/", routes.AttachNic)
+					r.Patch("/{nic}", routes.UpdateNic)
+					r.Delete("/{nic}", routes.DetachNic)
+				})
+				r.Route("/firewall", func(r chi.Router) {
+					r.Get("/", routes.GetFirewall)
+					r.Put("/", routes.SetFirewall)
+				})
+				r.Patch("/", routes.UpdateDomain)
 				r.Delete("/", routes.DeleteDomain)
 			})
 		})
@@ -76,3 +128,22 @@ func Service() *chi.Mux {
 
 	return r
 }
+
+// Service for browsers redeeming console tokens, it's served without client
+// certificates so nothing else belongs here. Only pages from origins may open
+// consoles.
+func ConsoleService(origins []string) *chi.Mux {
+	r := chi.NewMux()
+
+	routes.ConsoleOrigins = origins
+
+	r.Use(cm.RequestID)
+	r.Use(middleware.Logger)
+	r.Use(httprate.LimitByIP(100, 1*time.Minute))
+	r.Use(cm.NoCache)
+	r.Use(middleware.Recoverer)
+
+	r.Get("/console/{token}", routes.RedeemConsoleToken)
+
+	return r
+}
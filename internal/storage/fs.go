@@ -0,0 +1,113 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// qcow2 files in a directory, clones are overlays on top of the image
+type FS struct {
+	Dir    string
+	Runner Runner
+}
+
+func (b *FS) path(name string) string {
+	return filepath.Join(b.Dir, name+".qcow2")
+}
+
+func (b *FS) Create(name string, size int) (Volume, error) {
+	path := b.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return Volume{}, err
+	}
+	if _, err := b.Runner.Run("qemu-img", "create", "-f", "qcow2", path, gib(size)); err != nil {
+		return Volume{}, err
+	}
+	return Volume{Path: path, Format: "qcow2"}, nil
+}
+
+func (b *FS) Clone(image string, name string, size int) (Volume, error) {
+	path := b.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return Volume{}, err
+	}
+	if _, err := b.Runner.Run("qemu-img", "create", "-b", image, "-f", "qcow2", "-F", "qcow2", path, gib(size)); err != nil {
+		return Volume{}, err
+	}
+	return Volume{Path: path, Format: "qcow2"}, nil
+}
+
+func (b *FS) Resize(path string, size int) error {
+	return ResizeImage(b.Runner, path, size)
+}
+
+// Internal qcow2 snapshot, only safe while the domain is not running
+func (b *FS) Snapshot(path string, snap string) error {
+	_, err := b.Runner.Run("qemu-img", "snapshot", "-c", snap, path)
+	return err
+}
+
+// Removes the file, and the domain directory once it's empty
+func (b *FS) Delete(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return errors.New("file does not exist")
+	}
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if dir == filepath.Clean(b.Dir) {
+		return nil
+	}
+	entry, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entry) != 0 {
+		return nil
+	}
+	return os.Remove(dir)
+}
+
+func (b *FS) Owns(path string) bool {
+	return strings.HasPrefix(path, filepath.Clean(b.Dir)+"/")
+}
+
+func (b *FS) Free() (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(b.Dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+func (b *FS) Size() (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(b.Dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Blocks) * int64(st.Bsize), nil
+}
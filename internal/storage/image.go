@@ -0,0 +1,120 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrChecksum    = errors.New("checksum mismatch")
+	ErrImageExists = errors.New("image already exists")
+)
+
+// Writes r to path through a temporary file next to it, which is only
+// renamed into place once complete and matching sum, a hex SHA256. An empty
+// sum skips the check. Existing images are never replaced.
+func WriteImage(path string, r io.Reader, sum string) error {
+	if _, err := os.Stat(path); err == nil {
+		return ErrImageExists
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); sum != "" && !strings.EqualFold(got, sum) {
+		return fmt.Errorf("%w: got %s", ErrChecksum, got)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return ErrImageExists
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Downloads url into path like WriteImage, progress is reported in percent
+// when the server sends the size. The whole download, body included, is
+// bounded by ctx.
+func FetchImage(ctx context.Context, client *http.Client, url string, path string, sum string, progress func(int)) error {
+	if _, err := os.Stat(path); err == nil {
+		return ErrImageExists
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	var r io.Reader = resp.Body
+	if resp.ContentLength > 0 {
+		r = &progressReader{r: resp.Body, total: resp.ContentLength, progress: progress}
+	}
+	return WriteImage(path, r, sum)
+}
+
+type progressReader struct {
+	r        io.Reader
+	read     int64
+	total    int64
+	last     int
+	progress func(int)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if percent := int(p.read * 100 / p.total); percent != p.last && percent <= 100 {
+		p.last = percent
+		p.progress(percent)
+	}
+	return n, err
+}
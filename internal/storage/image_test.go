@@ -0,0 +1,152 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BasedDevelopment/auto/internal/storage"
+)
+
+var image = bytes.Repeat([]byte("auto"), 64*1024)
+
+func imageSum() string {
+	sum := sha256.Sum256(image)
+	return hex.EncodeToString(sum[:])
+}
+
+func imageServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/jammy.qcow2" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "jammy.qcow2", time.Time{}, bytes.NewReader(image))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Nothing but the image may be left in the directory
+func checkDir(t *testing.T, dir string, want ...string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	if len(got) != len(want) || (len(want) == 1 && got[0] != want[0]) {
+		t.Errorf("directory holds %v, want %v", got, want)
+	}
+}
+
+func TestFetchImage(t *testing.T) {
+	srv := imageServer(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "jammy.qcow2")
+
+	var last int
+	err := storage.FetchImage(context.Background(), srv.Client(), srv.URL+"/jammy.qcow2", path, imageSum(), func(p int) {
+		if p < last {
+			t.Errorf("progress went back from %d to %d", last, p)
+		}
+		last = p
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 100 {
+		t.Errorf("progress ended at %d", last)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, image) {
+		t.Error("image content mismatch")
+	}
+	checkDir(t, dir, "jammy.qcow2")
+
+	// Never replaced
+	err = storage.FetchImage(context.Background(), srv.Client(), srv.URL+"/jammy.qcow2", path, imageSum(), func(int) {})
+	if !errors.Is(err, storage.ErrImageExists) {
+		t.Errorf("expected ErrImageExists, got %v", err)
+	}
+}
+
+func TestFetchImageChecksum(t *testing.T) {
+	srv := imageServer(t)
+	dir := t.TempDir()
+
+	sum := hex.EncodeToString(make([]byte, sha256.Size))
+	err := storage.FetchImage(context.Background(), srv.Client(), srv.URL+"/jammy.qcow2", filepath.Join(dir, "jammy.qcow2"), sum, func(int) {})
+	if !errors.Is(err, storage.ErrChecksum) {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+	checkDir(t, dir)
+}
+
+func TestFetchImageNotFound(t *testing.T) {
+	srv := imageServer(t)
+	dir := t.TempDir()
+
+	err := storage.FetchImage(context.Background(), srv.Client(), srv.URL+"/missing.iso", filepath.Join(dir, "missing.iso"), imageSum(), func(int) {})
+	if err == nil {
+		t.Error("expected the download to fail")
+	}
+	checkDir(t, dir)
+}
+
+func TestFetchImageCanceled(t *testing.T) {
+	srv := imageServer(t)
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := storage.FetchImage(ctx, srv.Client(), srv.URL+"/jammy.qcow2", filepath.Join(dir, "jammy.qcow2"), imageSum(), func(int) {})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	checkDir(t, dir)
+}
+
+func TestWriteImage(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "install.iso")
+
+	if err := storage.WriteImage(path, bytes.NewReader(image), ""); err != nil {
+		t.Fatal(err)
+	}
+	checkDir(t, dir, "install.iso")
+}
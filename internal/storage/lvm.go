@@ -0,0 +1,116 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Thin volumes in an LVM thin pool
+type LVM struct {
+	VolumeGroup string
+	ThinPool    string
+	Runner      Runner
+}
+
+func (b *LVM) dev(lv string) string {
+	return "/dev/" + b.VolumeGroup + "/" + lv
+}
+
+// vg/lv of a device path
+func (b *LVM) lv(path string) string {
+	return b.VolumeGroup + "/" + filepath.Base(path)
+}
+
+func (b *LVM) Create(name string, size int) (Volume, error) {
+	lv := blockName(name)
+	if _, err := b.Runner.Run("lvcreate", "-y", "-V", gib(size), "-T", b.VolumeGroup+"/"+b.ThinPool, "-n", lv); err != nil {
+		return Volume{}, err
+	}
+	return Volume{Path: b.dev(lv), Format: "raw", Block: true}, nil
+}
+
+func (b *LVM) Clone(image string, name string, size int) (Volume, error) {
+	vol, err := b.Create(name, size)
+	if err != nil {
+		return vol, err
+	}
+	if err := convertOnto(b.Runner, image, vol.Path); err != nil {
+		b.Delete(vol.Path)
+		return Volume{}, err
+	}
+	return vol, nil
+}
+
+func (b *LVM) Resize(path string, size int) error {
+	_, err := b.Runner.Run("lvresize", "-L", gib(size), b.lv(path))
+	return err
+}
+
+// Thin snapshot, named <lv>-<snap>
+func (b *LVM) Snapshot(path string, snap string) error {
+	_, err := b.Runner.Run("lvcreate", "-y", "-s", "-n", filepath.Base(path)+"-"+snap, b.lv(path))
+	return err
+}
+
+func (b *LVM) Delete(path string) error {
+	_, err := b.Runner.Run("lvremove", "-y", b.lv(path))
+	return err
+}
+
+func (b *LVM) Owns(path string) bool {
+	return strings.HasPrefix(path, "/dev/"+b.VolumeGroup+"/")
+}
+
+// What's left of the thin pool data
+func (b *LVM) Free() (int64, error) {
+	size, used, err := b.pool()
+	return size - used, err
+}
+
+// Size of the thin pool data
+func (b *LVM) Size() (int64, error) {
+	size, _, err := b.pool()
+	return size, err
+}
+
+// Size and usage of the thin pool data, in bytes
+func (b *LVM) pool() (size int64, used int64, err error) {
+	out, err := b.Runner.Run("lvs", "--noheadings", "--nosuffix", "--units", "b", "-o", "lv_size,data_percent", b.VolumeGroup+"/"+b.ThinPool)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected lvs output: %q", out)
+	}
+	size, err = strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected lvs size: %q", fields[0])
+	}
+	percent, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected lvs data percent: %q", fields[1])
+	}
+	return size, int64(float64(size) * percent / 100), nil
+}
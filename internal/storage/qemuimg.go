@@ -0,0 +1,76 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// One image of the chain printed by qemu-img info --output=json
+type ImageInfo struct {
+	Filename            string          `json:"filename"`
+	Format              string          `json:"format"`
+	VirtualSize         int64           `json:"virtual-size"`
+	ActualSize          int64           `json:"actual-size"`
+	ClusterSize         int64           `json:"cluster-size"`
+	DirtyFlag           bool            `json:"dirty-flag"`
+	BackingFilename     string          `json:"backing-filename"`
+	FullBackingFilename string          `json:"full-backing-filename"`
+	BackingFormat       string          `json:"backing-filename-format"`
+	Snapshots           []ImageSnapshot `json:"snapshots"`
+	FormatSpecific      struct {
+		Type string `json:"type"`
+		Data struct {
+			Compat  string `json:"compat"`
+			Corrupt bool   `json:"corrupt"`
+		} `json:"data"`
+	} `json:"format-specific"`
+}
+
+// Internal snapshot of a qcow2 image
+type ImageSnapshot struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	VMStateSize int64  `json:"vm-state-size"`
+	DateSec     int64  `json:"date-sec"`
+}
+
+func (i ImageInfo) Corrupt() bool {
+	return i.FormatSpecific.Data.Corrupt
+}
+
+// Inspects the image at path along with its backing chain, the image itself
+// comes first. Images in use by a running domain are read without taking
+// the lock, so the numbers may be slightly off.
+func Info(runner Runner, path string) ([]ImageInfo, error) {
+	out, err := runner.Run("qemu-img", "info", "-U", "--output=json", "--backing-chain", path)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []ImageInfo
+	if err := json.Unmarshal(out, &chain); err != nil {
+		return nil, fmt.Errorf("failed to parse qemu-img info: %v", err)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("qemu-img info returned no image")
+	}
+	return chain, nil
+}
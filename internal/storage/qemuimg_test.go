@@ -0,0 +1,73 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BasedDevelopment/auto/internal/storage"
+)
+
+func TestInfo(t *testing.T) {
+	out, err := os.ReadFile(filepath.Join("testdata", "qemu-img-info.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRunner{out: out}
+
+	chain, err := storage.Info(r, "/var/lib/auto/disks/dom/0.qcow2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCmds(t, r, []string{
+		"qemu-img info -U --output=json --backing-chain /var/lib/auto/disks/dom/0.qcow2",
+	})
+
+	if len(chain) != 2 {
+		t.Fatalf("expected 2 images in the chain, got %d", len(chain))
+	}
+
+	top := chain[0]
+	if top.Format != "qcow2" || top.VirtualSize != 21474836480 || top.ActualSize != 1073741824 {
+		t.Errorf("unexpected sizes %+v", top)
+	}
+	if top.FullBackingFilename != "/var/lib/auto/cloud-images/jammy.qcow2" || top.BackingFormat != "qcow2" {
+		t.Errorf("unexpected backing file %q (%s)", top.FullBackingFilename, top.BackingFormat)
+	}
+	if len(top.Snapshots) != 1 || top.Snapshots[0].Name != "before-upgrade" || top.Snapshots[0].DateSec != 1685000000 {
+		t.Errorf("unexpected snapshots %+v", top.Snapshots)
+	}
+	if top.Corrupt() || top.DirtyFlag {
+		t.Error("top image should be clean")
+	}
+
+	if base := chain[1]; !base.Corrupt() || base.BackingFilename != "" {
+		t.Errorf("unexpected base image %+v", base)
+	}
+}
+
+func TestInfoInvalid(t *testing.T) {
+	for _, out := range []string{"", "[]", "{"} {
+		if _, err := storage.Info(&fakeRunner{out: []byte(out)}, "/tmp/x"); err == nil {
+			t.Errorf("expected %q to be rejected", out)
+		}
+	}
+}
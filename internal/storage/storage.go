@@ -0,0 +1,137 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Storage types, picked by the type of the [storage.<name>] section
+const (
+	TypeFS  = "fs"
+	TypeLVM = "lvm"
+	TypeZFS = "zfs"
+)
+
+// Where the disks of domains live. Volumes are named <domain>/<disk> and
+// addressed by the path handed to libvirt once created, sizes are in GiB.
+type Backend interface {
+	Create(name string, size int) (Volume, error)
+	// New volume starting out with the content of image
+	Clone(image string, name string, size int) (Volume, error)
+	Resize(path string, size int) error
+	Snapshot(path string, snap string) error
+	Delete(path string) error
+	// Whether the volume at path belongs to this backend
+	Owns(path string) bool
+	// Bytes left for volumes to grow into
+	Free() (int64, error)
+	// Bytes the volumes can take up in total
+	Size() (int64, error)
+}
+
+// A volume as attached to a domain, block volumes are raw devices
+type Volume struct {
+	Path   string
+	Format string
+	Block  bool
+}
+
+type Config struct {
+	Type string
+	// Directory holding the images, and the disks of fs storages
+	Path string
+	// LVM volume group and thin pool
+	VolumeGroup string
+	ThinPool    string
+	// Parent dataset of the zvols
+	Dataset string
+}
+
+// Runs the commands behind the backends, replaced in tests
+type Runner interface {
+	Run(name string, args ...string) ([]byte, error)
+}
+
+type ExecRunner struct{}
+
+func (ExecRunner) Run(name string, args ...string) ([]byte, error) {
+	log.Debug().
+		Str("command", name).
+		Strs("args", args).
+		Msg("storage command")
+
+	// Only stdout is returned as some callers parse it, stderr goes into
+	// the error
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		var stderr string
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			stderr = strings.TrimSpace(string(exitErr.Stderr))
+		}
+		return out, fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, stderr)
+	}
+	return out, nil
+}
+
+func New(c Config, runner Runner) (Backend, error) {
+	switch c.Type {
+	case "", TypeFS:
+		return &FS{Dir: c.Path + "/disks", Runner: runner}, nil
+	case TypeLVM:
+		if c.VolumeGroup == "" || c.ThinPool == "" {
+			return nil, fmt.Errorf("lvm storage needs volume_group and thin_pool")
+		}
+		return &LVM{VolumeGroup: c.VolumeGroup, ThinPool: c.ThinPool, Runner: runner}, nil
+	case TypeZFS:
+		if c.Dataset == "" {
+			return nil, fmt.Errorf("zfs storage needs dataset")
+		}
+		return &ZFS{Dataset: c.Dataset, Runner: runner}, nil
+	}
+	return nil, fmt.Errorf("unknown storage type %q", c.Type)
+}
+
+func gib(size int) string {
+	return strconv.Itoa(size) + "G"
+}
+
+// Block volumes can't be named with slashes
+func blockName(name string) string {
+	return strings.ReplaceAll(name, "/", "-")
+}
+
+// Grows an image file, qcow2 or raw
+func ResizeImage(runner Runner, path string, size int) error {
+	_, err := runner.Run("qemu-img", "resize", path, gib(size))
+	return err
+}
+
+// Writes image onto an existing block device
+func convertOnto(runner Runner, image string, dev string) error {
+	_, err := runner.Run("qemu-img", "convert", "-n", "-O", "raw", image, dev)
+	return err
+}
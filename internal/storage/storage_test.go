@@ -0,0 +1,264 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BasedDevelopment/auto/internal/storage"
+)
+
+// Records the commands instead of running them, failing the ones starting
+// with fail. Every command prints out.
+type fakeRunner struct {
+	cmds []string
+	fail string
+	out  []byte
+}
+
+func (r *fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	r.cmds = append(r.cmds, cmd)
+	if r.fail != "" && strings.HasPrefix(cmd, r.fail) {
+		return nil, errors.New("failed")
+	}
+	return r.out, nil
+}
+
+func newBackend(t *testing.T, c storage.Config, r *fakeRunner) storage.Backend {
+	t.Helper()
+	b, err := storage.New(c, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func checkCmds(t *testing.T, r *fakeRunner, want []string) {
+	t.Helper()
+	if !reflect.DeepEqual(r.cmds, want) {
+		t.Errorf("commands mismatch\n--- got ---\n%s\n--- want ---\n%s", strings.Join(r.cmds, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestFS(t *testing.T) {
+	dir := t.TempDir()
+	r := &fakeRunner{}
+	b := newBackend(t, storage.Config{Type: storage.TypeFS, Path: dir}, r)
+
+	disk := filepath.Join(dir, "disks", "dom", "0.qcow2")
+	vol, err := b.Create("dom/0", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vol != (storage.Volume{Path: disk, Format: "qcow2"}) {
+		t.Errorf("unexpected volume %+v", vol)
+	}
+	if _, err := b.Clone("/images/jammy.qcow2", "dom/1", 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Resize(disk, 15); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Snapshot(disk, "before"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkCmds(t, r, []string{
+		"qemu-img create -f qcow2 " + disk + " 10G",
+		"qemu-img create -b /images/jammy.qcow2 -f qcow2 -F qcow2 " + filepath.Join(dir, "disks", "dom", "1.qcow2") + " 20G",
+		"qemu-img resize " + disk + " 15G",
+		"qemu-img snapshot -c before " + disk,
+	})
+
+	if !b.Owns(disk) || b.Owns("/dev/vg0/dom-0") {
+		t.Error("wrong ownership")
+	}
+
+	// The fake runner doesn't create files, the domain directory goes once empty
+	if err := os.WriteFile(disk, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(disk); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Dir(disk)); !os.IsNotExist(err) {
+		t.Error("domain directory left behind")
+	}
+}
+
+func TestLVM(t *testing.T) {
+	r := &fakeRunner{}
+	b := newBackend(t, storage.Config{Type: storage.TypeLVM, VolumeGroup: "vg0", ThinPool: "thin"}, r)
+
+	vol, err := b.Clone("/images/jammy.qcow2", "dom/0", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vol != (storage.Volume{Path: "/dev/vg0/dom-0", Format: "raw", Block: true}) {
+		t.Errorf("unexpected volume %+v", vol)
+	}
+	if err := b.Resize(vol.Path, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Snapshot(vol.Path, "before"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(vol.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	checkCmds(t, r, []string{
+		"lvcreate -y -V 10G -T vg0/thin -n dom-0",
+		"qemu-img convert -n -O raw /images/jammy.qcow2 /dev/vg0/dom-0",
+		"lvresize -L 20G vg0/dom-0",
+		"lvcreate -y -s -n dom-0-before vg0/dom-0",
+		"lvremove -y vg0/dom-0",
+	})
+}
+
+func TestZFS(t *testing.T) {
+	r := &fakeRunner{}
+	b := newBackend(t, storage.Config{Type: storage.TypeZFS, Dataset: "tank/vms"}, r)
+
+	vol, err := b.Create("dom/0", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vol != (storage.Volume{Path: "/dev/zvol/tank/vms/dom-0", Format: "raw", Block: true}) {
+		t.Errorf("unexpected volume %+v", vol)
+	}
+	if err := b.Resize(vol.Path, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Snapshot(vol.Path, "before"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(vol.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	checkCmds(t, r, []string{
+		"zfs create -s -V 10G tank/vms/dom-0",
+		"udevadm settle --exit-if-exists=/dev/zvol/tank/vms/dom-0",
+		"zfs set volsize=20G tank/vms/dom-0",
+		"zfs snapshot tank/vms/dom-0@before",
+		"zfs destroy -r tank/vms/dom-0",
+	})
+
+	if !b.Owns(vol.Path) || b.Owns("/dev/zvol/tank/other/dom-0") {
+		t.Error("wrong ownership")
+	}
+}
+
+// A failed copy must not leave the new volume behind
+func TestCloneCleanup(t *testing.T) {
+	r := &fakeRunner{fail: "qemu-img convert"}
+	b := newBackend(t, storage.Config{Type: storage.TypeZFS, Dataset: "tank"}, r)
+
+	if _, err := b.Clone("/images/jammy.qcow2", "dom/0", 10); err == nil {
+		t.Fatal("expected clone to fail")
+	}
+	if last := r.cmds[len(r.cmds)-1]; last != "zfs destroy -r tank/dom-0" {
+		t.Errorf("volume not cleaned up, last command %q", last)
+	}
+}
+
+func TestFree(t *testing.T) {
+	lvm := newBackend(t, storage.Config{Type: storage.TypeLVM, VolumeGroup: "vg0", ThinPool: "thin"},
+		&fakeRunner{out: []byte("  107374182400 25.00\n")})
+	if free, err := lvm.Free(); err != nil || free != 80530636800 {
+		t.Errorf("lvm: got %d, %v", free, err)
+	}
+
+	zfs := newBackend(t, storage.Config{Type: storage.TypeZFS, Dataset: "tank"},
+		&fakeRunner{out: []byte("53687091200\n")})
+	if free, err := zfs.Free(); err != nil || free != 53687091200 {
+		t.Errorf("zfs: got %d, %v", free, err)
+	}
+
+	broken := newBackend(t, storage.Config{Type: storage.TypeZFS, Dataset: "tank"},
+		&fakeRunner{out: []byte("-\n")})
+	if _, err := broken.Free(); err == nil {
+		t.Error("expected unparsable output to fail")
+	}
+}
+
+func TestSize(t *testing.T) {
+	lvm := newBackend(t, storage.Config{Type: storage.TypeLVM, VolumeGroup: "vg0", ThinPool: "thin"},
+		&fakeRunner{out: []byte("  107374182400 25.00\n")})
+	if size, err := lvm.Size(); err != nil || size != 107374182400 {
+		t.Errorf("lvm: got %d, %v", size, err)
+	}
+
+	r := &fakeRunner{out: []byte("10737418240\n53687091200\n")}
+	zfs := newBackend(t, storage.Config{Type: storage.TypeZFS, Dataset: "tank"}, r)
+	if size, err := zfs.Size(); err != nil || size != 64424509440 {
+		t.Errorf("zfs: got %d, %v", size, err)
+	}
+	checkCmds(t, r, []string{"zfs get -Hp -o value used,available tank"})
+
+	broken := newBackend(t, storage.Config{Type: storage.TypeZFS, Dataset: "tank"},
+		&fakeRunner{out: []byte("53687091200\n")})
+	if _, err := broken.Size(); err == nil {
+		t.Error("expected a single value to fail")
+	}
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "disks"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fs := newBackend(t, storage.Config{Type: storage.TypeFS, Path: dir}, &fakeRunner{})
+	if size, err := fs.Size(); err != nil || size <= 0 {
+		t.Errorf("fs: got %d, %v", size, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, c := range []storage.Config{
+		{Type: "btrfs"},
+		{Type: storage.TypeLVM, VolumeGroup: "vg0"},
+		{Type: storage.TypeZFS},
+	} {
+		if _, err := storage.New(c, &fakeRunner{}); err == nil {
+			t.Errorf("expected %+v to be rejected", c)
+		}
+	}
+}
+
+// Output gets parsed, stderr must stay out of it and end up in the error
+func TestExecRunnerStderr(t *testing.T) {
+	out, err := storage.ExecRunner{}.Run("sh", "-c", "echo '{}'; echo warning >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "{}\n" {
+		t.Errorf("got output %q, want %q", out, "{}\n")
+	}
+
+	_, err = storage.ExecRunner{}.Run("sh", "-c", "echo '{}'; echo broken >&2; exit 1")
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected stderr in the error, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const zvolDir = "/dev/zvol/"
+
+// Sparse zvols under a dataset
+type ZFS struct {
+	Dataset string
+	Runner  Runner
+}
+
+func (b *ZFS) dev(vol string) string {
+	return zvolDir + b.Dataset + "/" + vol
+}
+
+// Dataset name of a device path
+func (b *ZFS) volume(path string) string {
+	return strings.TrimPrefix(path, zvolDir)
+}
+
+func (b *ZFS) Create(name string, size int) (Volume, error) {
+	vol := blockName(name)
+	if _, err := b.Runner.Run("zfs", "create", "-s", "-V", gib(size), b.Dataset+"/"+vol); err != nil {
+		return Volume{}, err
+	}
+
+	// The device node shows up through udev
+	dev := b.dev(vol)
+	if _, err := b.Runner.Run("udevadm", "settle", "--exit-if-exists="+dev); err != nil {
+		return Volume{}, err
+	}
+	return Volume{Path: dev, Format: "raw", Block: true}, nil
+}
+
+func (b *ZFS) Clone(image string, name string, size int) (Volume, error) {
+	vol, err := b.Create(name, size)
+	if err != nil {
+		return vol, err
+	}
+	if err := convertOnto(b.Runner, image, vol.Path); err != nil {
+		b.Delete(vol.Path)
+		return Volume{}, err
+	}
+	return vol, nil
+}
+
+func (b *ZFS) Resize(path string, size int) error {
+	_, err := b.Runner.Run("zfs", "set", "volsize="+gib(size), b.volume(path))
+	return err
+}
+
+func (b *ZFS) Snapshot(path string, snap string) error {
+	_, err := b.Runner.Run("zfs", "snapshot", b.volume(path)+"@"+snap)
+	return err
+}
+
+// Snapshots go along with the zvol
+func (b *ZFS) Delete(path string) error {
+	_, err := b.Runner.Run("zfs", "destroy", "-r", b.volume(path))
+	return err
+}
+
+func (b *ZFS) Owns(path string) bool {
+	return strings.HasPrefix(path, zvolDir+b.Dataset+"/")
+}
+
+func (b *ZFS) Free() (int64, error) {
+	out, err := b.Runner.Run("zfs", "get", "-Hp", "-o", "value", "available", b.Dataset)
+	if err != nil {
+		return 0, err
+	}
+
+	free, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected zfs output: %q", out)
+	}
+	return free, nil
+}
+
+// Used and available space of the dataset together, what it could hold if
+// nothing else took from the pool
+func (b *ZFS) Size() (int64, error) {
+	out, err := b.Runner.Run("zfs", "get", "-Hp", "-o", "value", "used,available", b.Dataset)
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("unexpected zfs output: %q", out)
+	}
+	var size int64
+	for _, f := range fields {
+		v, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("unexpected zfs output: %q", out)
+		}
+		size += v
+	}
+	return size, nil
+}
@@ -2,12 +2,26 @@ package util
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
 )
 
+var (
+	snapshotNameRe = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	// Plain file names, no hidden files since uploads in progress are hidden
+	imageNameRe = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+	sha256Re    = regexp.MustCompile(`^[A-Fa-f0-9]{64}$`)
+)
+
 type Validatable[T any] interface {
 	Validate() error
 	*T
@@ -15,39 +29,135 @@ type Validatable[T any] interface {
 
 type Request interface {
 	SetDomainStateRequest |
-		DomainCreateRequest
+		DomainCreateRequest |
+		DomainUpdateRequest |
+		SnapshotCreateRequest |
+		DomainMigrateRequest |
+		ConsoleTokenRequest |
+		ImageFetchRequest |
+		DiskResizeRequest |
+		FirewallRequest |
+		NicUpdateRequest |
+		IfaceRequest |
+		DiskAttachRequest
 }
 
 type SetDomainStateRequest struct {
 	State string `json:"state"`
+	// poweroff only: how to ask the guest, how many seconds to wait for it
+	// to shut off and whether to destroy it if it doesn't by then
+	Method  string `json:"method"`
+	Timeout int    `json:"timeout"`
+	Force   bool   `json:"force"`
 }
 
 func (r *SetDomainStateRequest) Validate() error {
-	return validation.ValidateStruct(r,
-		validation.Field(&r.State, validation.Required, validation.In("start", "reboot", "poweroff", "stop", "reset")),
-	)
+	if err := validation.ValidateStruct(r,
+		validation.Field(&r.State, validation.Required, validation.In("start", "reboot", "poweroff", "stop", "reset", "pause", "resume", "suspend", "wakeup", "save", "restore")),
+		validation.Field(&r.Method, validation.In("acpi", "agent", "initctl", "signal", "paravirt")),
+		validation.Field(&r.Timeout, validation.Min(0), validation.Max(600)),
+	); err != nil {
+		return err
+	}
+	if r.Force && r.Timeout == 0 {
+		return errors.New("force needs a timeout")
+	}
+	return nil
 }
 
 type DomainCreateRequest struct {
-	ID         string `json:"id"`
-	Hostname   string `json:"hostname"`
-	CPU        int    `json:"cpu"`
-	Memory     int    `json:"memory"`
-	Image      string `json:"image"`
-	Cloud      bool   `json:"cloud"`
-	CloudImage string `json:"cloud_image"`
-	OSVariant  string `json:"os_variant"`
-	UserData   string `json:"user_data"`
-	MetaData   string `json:"meta_data"`
+	ID         string   `json:"id"`
+	Hostname   string   `json:"hostname"`
+	CPU        int      `json:"cpu"`
+	Memory     int      `json:"memory"`
+	Image      string   `json:"image"`
+	Cloud      bool     `json:"cloud"`
+	CloudImage string   `json:"cloud_image"`
+	OSVariant  string   `json:"os_variant"`
+	UserData   string   `json:"user_data"`
+	MetaData   string   `json:"meta_data"`
+	Boot       []string `json:"boot"`
 	Disk       []struct {
 		ID   int    `json:"id"`
 		Size int    `json:"size"`
 		Path string `json:"path"`
 	} `json:"disk"`
-	Iface []struct {
-		Bridge string `json:"bridge"`
-		MAC    string `json:"mac"`
-	} `json:"iface"`
+	Iface []IfaceRequest `json:"iface"`
+}
+
+// A NIC on a configured network, VLANs need an OVS bridge
+type IfaceRequest struct {
+	Bridge string `json:"bridge"`
+	MAC    string `json:"mac"`
+	// virtio unless given
+	Model string `json:"model"`
+	// Access VLAN, or the native VLAN of the trunk if there is one
+	VLAN  int   `json:"vlan"`
+	Trunk []int `json:"trunk"`
+	// virtio queues, more than one enables multiqueue
+	Queues    int              `json:"queues"`
+	Bandwidth BandwidthRequest `json:"bandwidth"`
+}
+
+func (r *IfaceRequest) Validate() error {
+	if err := validation.ValidateStruct(r,
+		validation.Field(&r.Bridge, validation.Required),
+		validation.Field(&r.MAC, is.MAC),
+		validation.Field(&r.Model, validation.In("virtio", "e1000", "e1000e", "rtl8139")),
+		validation.Field(&r.VLAN, validation.Min(0), validation.Max(4094)),
+		validation.Field(&r.Trunk, validation.Each(validation.Min(1), validation.Max(4094))),
+		validation.Field(&r.Queues, validation.Min(0), validation.Max(256)),
+	); err != nil {
+		return err
+	}
+	if r.Queues > 1 && r.Model != "" && r.Model != "virtio" {
+		return errors.New("multiqueue needs virtio")
+	}
+	return r.Bandwidth.Validate()
+}
+
+// NIC bandwidth limits, inbound is traffic towards the domain
+type BandwidthRequest struct {
+	Inbound  BandwidthLimit `json:"inbound"`
+	Outbound BandwidthLimit `json:"outbound"`
+}
+
+// Average and peak in KiB/s, burst in KiB. A zero average lifts the limit.
+type BandwidthLimit struct {
+	Average int `json:"average"`
+	Peak    int `json:"peak"`
+	Burst   int `json:"burst"`
+}
+
+func (r *BandwidthRequest) Validate() error {
+	for dir, l := range map[string]*BandwidthLimit{"inbound": &r.Inbound, "outbound": &r.Outbound} {
+		if err := validation.ValidateStruct(l,
+			validation.Field(&l.Average, validation.Min(0), validation.Max(int64(math.MaxUint32))),
+			validation.Field(&l.Peak, validation.Min(0), validation.Max(int64(math.MaxUint32))),
+			validation.Field(&l.Burst, validation.Min(0), validation.Max(int64(math.MaxUint32))),
+		); err != nil {
+			return fmt.Errorf("%s: %v", dir, err)
+		}
+		if l.Average == 0 && (l.Peak != 0 || l.Burst != 0) {
+			return fmt.Errorf("%s: peak and burst need an average", dir)
+		}
+		if l.Peak != 0 && l.Peak < l.Average {
+			return fmt.Errorf("%s: peak can't be below the average", dir)
+		}
+	}
+	return nil
+}
+
+// Live changes to a NIC
+type NicUpdateRequest struct {
+	Bandwidth *BandwidthRequest `json:"bandwidth"`
+}
+
+func (r *NicUpdateRequest) Validate() error {
+	if r.Bandwidth == nil {
+		return errors.New("nothing to update")
+	}
+	return r.Bandwidth.Validate()
 }
 
 func (r *DomainCreateRequest) Validate() error {
@@ -59,14 +169,233 @@ func (r *DomainCreateRequest) Validate() error {
 			return err
 		}
 	}
-	return validation.ValidateStruct(r,
+	if err := validation.ValidateStruct(r,
 		validation.Field(&r.Hostname, validation.Required, is.Domain),
 		validation.Field(&r.CPU, validation.Required, validation.Min(1)),
 		validation.Field(&r.Memory, validation.Required, validation.Min(1)),
+		validation.Field(&r.Boot, validation.Each(validation.In("hd", "cdrom", "network"))),
 		// Validation of disk and image path is not here due to import cycle
+	); err != nil {
+		return err
+	}
+	for i := range r.Iface {
+		if err := r.Iface[i].Validate(); err != nil {
+			return fmt.Errorf("iface: %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Partial domain spec, nil fields are left untouched
+type DomainUpdateRequest struct {
+	CPU         *int     `json:"cpu"`
+	Memory      *int     `json:"memory"`
+	MaxMemory   *int     `json:"max_memory"`
+	Description *string  `json:"description"`
+	Autostart   *bool    `json:"autostart"`
+	Boot        []string `json:"boot"`
+}
+
+func (r *DomainUpdateRequest) Validate() error {
+	if r.CPU == nil && r.Memory == nil && r.MaxMemory == nil &&
+		r.Description == nil && r.Autostart == nil && r.Boot == nil {
+		return errors.New("nothing to update")
+	}
+	if err := validation.ValidateStruct(r,
+		validation.Field(&r.CPU, validation.NilOrNotEmpty, validation.Min(1)),
+		validation.Field(&r.Memory, validation.NilOrNotEmpty, validation.Min(1)),
+		validation.Field(&r.MaxMemory, validation.NilOrNotEmpty, validation.Min(1)),
+		validation.Field(&r.Boot, validation.Each(validation.In("hd", "cdrom", "network"))),
+	); err != nil {
+		return err
+	}
+	if r.Memory != nil && r.MaxMemory != nil && *r.Memory > *r.MaxMemory {
+		return errors.New("memory can't be larger than max_memory")
+	}
+	return nil
+}
+
+type SnapshotCreateRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Disk-only snapshot as external overlays instead of internal qcow2 ones,
+	// those can't be reverted or deleted and stay until the domain is deleted
+	External bool `json:"external"`
+	Quiesce  bool `json:"quiesce"`
+}
+
+func (r *SnapshotCreateRequest) Validate() error {
+	if err := validation.ValidateStruct(r,
+		validation.Field(&r.Name, validation.Required, validation.Length(1, 64), validation.Match(snapshotNameRe)),
+	); err != nil {
+		return err
+	}
+	if r.Quiesce && !r.External {
+		return errors.New("only external snapshots can be quiesced")
+	}
+	return nil
+}
+
+type DomainMigrateRequest struct {
+	// Name of the peer in the config
+	Peer string `json:"peer"`
+	Live bool   `json:"live"`
+	// Copy the disks along when the hosts don't share storage
+	CopyStorage bool `json:"copy_storage"`
+	// MiB/s, 0 is unlimited
+	Bandwidth int `json:"bandwidth"`
+}
+
+func (r *DomainMigrateRequest) Validate() error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Peer, validation.Required),
+		validation.Field(&r.Bandwidth, validation.Min(0)),
+	)
+}
+
+type ConsoleTokenRequest struct {
+	Type string `json:"type"`
+}
+
+func (r *ConsoleTokenRequest) Validate() error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Type, validation.Required, validation.In("vnc", "serial")),
+	)
+}
+
+// Image to download into a storage
+type ImageFetchRequest struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+func (r *ImageFetchRequest) Validate() error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Name, ImageNameRules...),
+		validation.Field(&r.URL, validation.Required, is.URL, validation.Match(regexp.MustCompile(`^https?://`))),
+		validation.Field(&r.SHA256, validation.Required, validation.Match(sha256Re)),
+	)
+}
+
+// Rules for image file names, uploads take the name from the URL
+var ImageNameRules = []validation.Rule{
+	validation.Required,
+	validation.Length(1, 255),
+	validation.Match(imageNameRe),
+}
+
+type DiskResizeRequest struct {
+	// New size in GiB, disks only grow
+	Size int `json:"size"`
+}
+
+func (r *DiskResizeRequest) Validate() error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Size, validation.Required, validation.Min(1)),
+	)
+}
+
+// New disk for a domain, Storage names the storage or its disks directory
+type DiskAttachRequest struct {
+	Storage string `json:"storage"`
+	// GiB
+	Size int `json:"size"`
+}
+
+func (r *DiskAttachRequest) Validate() error {
+	return validation.ValidateStruct(r,
+		validation.Field(&r.Storage, validation.Required),
+		validation.Field(&r.Size, validation.Required, validation.Min(1)),
 	)
 }
 
+// Replaces the whole firewall of a domain, empty policies accept
+type FirewallRequest struct {
+	IngressPolicy string `json:"ingress_policy"`
+	EgressPolicy  string `json:"egress_policy"`
+	// Source addresses or CIDRs each NIC may use, by MAC
+	Addresses map[string][]string `json:"addresses"`
+	Rules     []FirewallRule      `json:"rules"`
+}
+
+type FirewallRule struct {
+	Direction string `json:"direction"`
+	Action    string `json:"action"`
+	Protocol  string `json:"protocol"`
+	Port      string `json:"port"`
+	CIDR      string `json:"cidr"`
+}
+
+func (r *FirewallRequest) Validate() error {
+	if err := validation.ValidateStruct(r,
+		validation.Field(&r.IngressPolicy, validation.In("accept", "drop")),
+		validation.Field(&r.EgressPolicy, validation.In("accept", "drop")),
+		validation.Field(&r.Rules, validation.Length(0, 256)),
+	); err != nil {
+		return err
+	}
+	for mac, addrs := range r.Addresses {
+		if err := validation.Validate(mac, is.MAC); err != nil {
+			return fmt.Errorf("addresses: %s: %v", mac, err)
+		}
+		if err := validation.Validate(addrs, validation.Each(validation.Required, validation.By(isIPOrCIDR))); err != nil {
+			return fmt.Errorf("addresses: %s: %v", mac, err)
+		}
+	}
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if err := validation.ValidateStruct(rule,
+			validation.Field(&rule.Direction, validation.Required, validation.In("ingress", "egress")),
+			validation.Field(&rule.Action, validation.Required, validation.In("accept", "drop")),
+			validation.Field(&rule.Protocol, validation.In("any", "tcp", "udp", "icmp", "icmpv6")),
+			validation.Field(&rule.Port, validation.By(isPorts)),
+			validation.Field(&rule.CIDR, validation.By(isIPOrCIDR)),
+		); err != nil {
+			return fmt.Errorf("rules: %d: %v", i, err)
+		}
+		if rule.Port != "" && rule.Protocol != "tcp" && rule.Protocol != "udp" {
+			return fmt.Errorf("rules: %d: port needs tcp or udp", i)
+		}
+	}
+	return nil
+}
+
+// A port or an ascending range of them
+func isPorts(value interface{}) error {
+	s, _ := value.(string)
+	if s == "" {
+		return nil
+	}
+	lo, hi, isRange := strings.Cut(s, "-")
+	from, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil || from == 0 {
+		return errors.New("must be a port between 1 and 65535 or a range of them")
+	}
+	if !isRange {
+		return nil
+	}
+	to, err := strconv.ParseUint(hi, 10, 16)
+	if err != nil || to == 0 || to < from {
+		return errors.New("must be a port between 1 and 65535 or a range of them")
+	}
+	return nil
+}
+
+func isIPOrCIDR(value interface{}) error {
+	s, _ := value.(string)
+	if s == "" {
+		return nil
+	}
+	if net.ParseIP(s) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(s); err != nil {
+		return errors.New("must be an IP address or CIDR")
+	}
+	return nil
+}
+
 func ParseRequest[R Request, T Validatable[R]](r *http.Request, rq T) error {
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(rq); err != nil {
@@ -0,0 +1,60 @@
+/*
+ * eve - management toolkit for libvirt servers
+ * Copyright (C) 2022-2023  BNS Services LLC
+
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package util
+
+import "testing"
+
+func TestFirewallRulePorts(t *testing.T) {
+	for port, ok := range map[string]bool{
+		"":            true,
+		"22":          true,
+		"80-443":      true,
+		"1-65535":     true,
+		"0":           false,
+		"65536":       false,
+		"99999":       false,
+		"443-80":      false,
+		"80-":         false,
+		"-80":         false,
+		"80-443-8080": false,
+		"http":        false,
+	} {
+		req := &FirewallRequest{Rules: []FirewallRule{{
+			Direction: "ingress",
+			Action:    "accept",
+			Protocol:  "tcp",
+			Port:      port,
+		}}}
+		if err := req.Validate(); (err == nil) != ok {
+			t.Errorf("%q: got %v, want ok %v", port, err, ok)
+		}
+	}
+}
+
+func TestDomainUpdateRequestEmpty(t *testing.T) {
+	if err := new(DomainUpdateRequest).Validate(); err == nil || err.Error() != "nothing to update" {
+		t.Errorf("empty update: got %v", err)
+	}
+
+	autostart := false
+	req := &DomainUpdateRequest{Autostart: &autostart}
+	if err := req.Validate(); err != nil {
+		t.Errorf("autostart only: got %v", err)
+	}
+}
@@ -1,7 +1,7 @@
 package models
 
 import (
-	"net"
+	"crypto/tls"
 	"sync"
 	"time"
 
@@ -14,8 +14,8 @@ import (
 // struct in eve, which persists the data to the database.
 type HV struct {
 	Mutex          sync.Mutex            `json:"-"`
-	IP             net.IP                `json:"-"`
-	Port           int                   `json:"-"`
+	URI            string                `json:"-"`
+	TLSConfig      *tls.Config           `json:"-"`
 	CPUModel       string                `json:"cpu_model"`
 	Arch           string                `json:"arch"`
 	RAMTotal       uint64                `json:"total_ram"`
@@ -29,23 +29,88 @@ type HV struct {
 	Brs            map[string]*HVBr      `json:"-"`
 	Storages       map[string]*HVStorage `json:"-"`
 	VMs            map[uuid.UUID]*VM     `json:"-"`
+	Peers          map[string]*HVPeer    `json:"peers"`
 	Status         status.Status         `json:"status"`
 	StatusReason   string                `json:"status_reason"`
+	StatusUpdated  time.Time             `json:"status_updated"`
 	QemuVersion    string                `json:"qemu_version"`
 	LibvirtVersion string                `json:"libvirt_version"`
 	Libvirt        *libvirt.Libvirt      `json:"-"`
 }
 
+// A bridge from the config and its live state on the host, Speed is in
+// Mbit/s and Addresses in CIDR notation
 type HVBr struct {
-	Name    string `json:"name"`
-	Remarks string `json:"remarks"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Active    bool      `json:"active"`
+	State     string    `json:"state"`
+	Speed     int       `json:"speed"`
+	MAC       string    `json:"mac"`
+	Addresses []string  `json:"addresses"`
+	Ports     []string  `json:"ports"`
+	Updated   time.Time `json:"updated"`
+	Remarks   string    `json:"remarks"`
 }
 
+// A storage from the config, sizes in bytes
 type HVStorage struct {
-	ID      uuid.UUID `json:"id"`
-	Size    int       `json:"size"`
-	Used    int       `json:"used"`
-	Free    int       `json:"free"`
-	Updated time.Time `json:"updated"`
-	Remarks string    `json:"remarks"`
+	Mutex       sync.Mutex   `json:"-"`
+	Pool        libvirt.Pool `json:"-"`
+	ID          uuid.UUID    `json:"id"`
+	Name        string       `json:"name"`
+	Type        string       `json:"type"`
+	Path        string       `json:"path"`
+	Images      bool         `json:"images"`
+	CloudImages bool         `json:"cloud_images"`
+	Disks       bool         `json:"disks"`
+	Size        int          `json:"size"`
+	Used        int          `json:"used"`
+	Free        int          `json:"free"`
+	Updated     time.Time    `json:"updated"`
+	Remarks     string       `json:"remarks"`
+}
+
+// A file in a storage, VirtualSize is the size the guest sees
+type StorageFile struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Format      string `json:"format"`
+	Size        int64  `json:"size"`
+	VirtualSize int64  `json:"virtual_size"`
+}
+
+// Another auto host domains can be migrated to
+type HVPeer struct {
+	Name          string `json:"name"`
+	URI           string `json:"uri"`
+	SharedStorage bool   `json:"shared_storage"`
+}
+
+// A storage file as qemu-img sees it, sizes in bytes
+type ImageInfo struct {
+	Path          string          `json:"path"`
+	Format        string          `json:"format"`
+	VirtualSize   int64           `json:"virtual_size"`
+	ActualSize    int64           `json:"actual_size"`
+	Dirty         bool            `json:"dirty"`
+	Corrupt       bool            `json:"corrupt"`
+	BackingFile   string          `json:"backing_file,omitempty"`
+	BackingFormat string          `json:"backing_format,omitempty"`
+	Snapshots     []ImageSnapshot `json:"snapshots"`
+}
+
+type ImageSnapshot struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	VMStateSize int64     `json:"vm_state_size"`
+	Created     time.Time `json:"created"`
+}
+
+// Details of a storage file, the backing chain goes from the nearest
+// backing file down to the base image
+type StorageFileInfo struct {
+	Name string `json:"name"`
+	ImageInfo
+	BackingChain []ImageInfo `json:"backing_chain"`
 }
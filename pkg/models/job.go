@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// A long-running operation running in the background, polled through /jobs
+type Job struct {
+	ID       uuid.UUID   `json:"id"`
+	Type     string      `json:"type"`
+	Domain   uuid.UUID   `json:"domain"`
+	Path     string      `json:"path,omitempty"`
+	Status   JobStatus   `json:"status"`
+	Progress int         `json:"progress"`
+	Error    string      `json:"error,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+	Created  time.Time   `json:"created"`
+	Started  time.Time   `json:"started"`
+	Finished time.Time   `json:"finished"`
+}
@@ -11,39 +11,182 @@ import (
 )
 
 type VM struct {
-	Mutex    sync.Mutex           `json:"-"`
-	Domain   libvirt.Dom          `json:"-"`
-	ID       uuid.UUID            `json:"id"`
-	CPU      int                  `json:"cpu"`
-	Memory   int64                `json:"memory"`
-	Nics     map[string]VMNic     `json:"nics"`
-	Storages map[string]VMStorage `json:"storages"`
+	Mutex    sync.Mutex            `json:"-"`
+	Domain   libvirt.Dom           `json:"-"`
+	ID       uuid.UUID             `json:"id"`
+	CPU      int                   `json:"cpu"`
+	Memory   int64                 `json:"memory"`
+	Nics     map[string]*VMNic     `json:"nics"`
+	Storages map[string]*VMStorage `json:"storages"`
+	Graphics []VMGraphics          `json:"graphics"`
+	USBs     []VMUSB               `json:"usbs"`
+	Serial   VMSerial              `json:"serial"`
+	State    VMState               `json:"state"`
 }
 
 type VMState struct {
 	State       status.Status `json:"state"`
 	StateStr    string        `json:"state_str"`
 	StateReason string        `json:"state_reason"`
+	ManagedSave bool          `json:"managed_save"`
+	Updated     time.Time     `json:"updated"`
 }
 
+// State after a state action, for poweroff Shutdown tells how it went:
+// requested (not waited for), graceful, forced or timeout
+type VMStateChange struct {
+	VMState
+	Shutdown string `json:"shutdown,omitempty"`
+}
+
+type VMSnapshot struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Parent      string    `json:"parent"`
+	State       string    `json:"state"`
+	External    bool      `json:"external"`
+	Current     bool      `json:"current"`
+	Created     time.Time `json:"created"`
+}
+
+// Outcome of each step of a domain deletion
+type VMDeleteResult struct {
+	ID    uuid.UUID            `json:"id"`
+	Steps []VMDeleteStepResult `json:"steps"`
+}
+
+type VMDeleteStepResult struct {
+	Step string `json:"step"`
+	Path string `json:"path,omitempty"`
+	OK   bool   `json:"ok"`
+	// Not attempted, the file isn't one auto manages
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Outcome of detaching a disk and deleting its volume
+type DiskDetachResult struct {
+	Dev   string               `json:"dev"`
+	Steps []VMDeleteStepResult `json:"steps"`
+}
+
+// Fields changed by an update, and the ones that only apply on the next boot
+type VMUpdateResult struct {
+	Updated        []string `json:"updated"`
+	RebootRequired []string `json:"reboot_required"`
+}
+
+// Nics are keyed by MAC address
 type VMNic struct {
-	Mutex   sync.Mutex `json:"-"`
-	ID      uuid.UUID  `json:"id"`
-	name    string     `json:"name"`
-	MAC     string     `json:"mac"`
-	IP      []net.IP   `json:"ip"`
-	Created time.Time  `json:"created"`
-	Updated time.Time  `json:"updated"`
-	Remarks string     `json:"remarks"`
-	State   string     `json:"state"`
+	Mutex     sync.Mutex  `json:"-"`
+	ID        uuid.UUID   `json:"id"`
+	Name      string      `json:"name"`
+	MAC       string      `json:"mac"`
+	Bridge    string      `json:"bridge"`
+	Model     string      `json:"model"`
+	VLAN      int         `json:"vlan"`
+	Trunk     []int       `json:"trunk"`
+	Queues    int         `json:"queues"`
+	Bandwidth VMBandwidth `json:"bandwidth"`
+	TargetDev string      `json:"target_dev"`
+	IP        []net.IP    `json:"ip"`
+	Created   time.Time   `json:"created"`
+	Updated   time.Time   `json:"updated"`
+	Remarks   string      `json:"remarks"`
+	State     string      `json:"state"`
 }
 
+// Storages are keyed by target device (vda, sda...)
 type VMStorage struct {
-	Mutex   sync.Mutex `json:"-"`
-	ID      uuid.UUID  `json:"id"`
-	Path    string     `json:"path"`
-	Size    int        `json:"size"`
-	Created time.Time  `json:"created"`
-	Updated time.Time  `json:"updated"`
-	Remarks string     `json:"remarks"`
+	Mutex      sync.Mutex `json:"-"`
+	ID         uuid.UUID  `json:"id"`
+	Path       string     `json:"path"`
+	Device     string     `json:"device"`
+	TargetDev  string     `json:"target_dev"`
+	Bus        string     `json:"bus"`
+	Format     string     `json:"format"`
+	Size       int        `json:"size"`
+	Allocation int        `json:"allocation"`
+	Created    time.Time  `json:"created"`
+	Updated    time.Time  `json:"updated"`
+	Remarks    string     `json:"remarks"`
+}
+
+type VMGraphics struct {
+	Type      string `json:"type"`
+	Port      string `json:"port"`
+	Websocket string `json:"websocket"`
+	Listen    string `json:"listen"`
+}
+
+// The serial console, attached to through /console/serial
+type VMSerial struct {
+	Available bool   `json:"available"`
+	Type      string `json:"type"`
+	// Input can only be sent to local pty consoles
+	Writable bool `json:"writable"`
+}
+
+// Single-use token redeemed on the console listener at Path
+type ConsoleToken struct {
+	Token   string    `json:"token"`
+	Type    string    `json:"type"`
+	Domain  uuid.UUID `json:"domain"`
+	Path    string    `json:"path"`
+	Expires time.Time `json:"expires"`
+}
+
+type VMUSB struct {
+	Vendor  string `json:"vendor"`
+	Product string `json:"product"`
+	Bus     string `json:"bus"`
+	Device  string `json:"device"`
+}
+
+// Progress of a migration, sizes in bytes and times in ms
+type VMMigration struct {
+	Peer            string `json:"peer"`
+	Active          bool   `json:"active"`
+	TimeElapsed     uint64 `json:"time_elapsed"`
+	TimeRemaining   uint64 `json:"time_remaining"`
+	DataTotal       uint64 `json:"data_total"`
+	DataProcessed   uint64 `json:"data_processed"`
+	DataRemaining   uint64 `json:"data_remaining"`
+	MemoryRemaining uint64 `json:"memory_remaining"`
+	MemoryBps       uint64 `json:"memory_bps"`
+	DiskRemaining   uint64 `json:"disk_remaining"`
+	Iteration       uint64 `json:"iteration"`
+	Downtime        uint64 `json:"expected_downtime"`
+}
+
+// Firewall of a domain, Addresses holds the source addresses each NIC may
+// use by MAC, NICs without any are only held to their MAC
+type VMFirewall struct {
+	IngressPolicy string              `json:"ingress_policy"`
+	EgressPolicy  string              `json:"egress_policy"`
+	Addresses     map[string][]string `json:"addresses"`
+	Rules         []VMFirewallRule    `json:"rules"`
+	Updated       time.Time           `json:"updated"`
+}
+
+// CIDR is the remote end, Port the destination port or range
+type VMFirewallRule struct {
+	Direction string `json:"direction"`
+	Action    string `json:"action"`
+	Protocol  string `json:"protocol,omitempty"`
+	Port      string `json:"port,omitempty"`
+	CIDR      string `json:"cidr,omitempty"`
+}
+
+// Bandwidth limits of a NIC, inbound is traffic towards the domain
+type VMBandwidth struct {
+	Inbound  VMBandwidthLimit `json:"inbound"`
+	Outbound VMBandwidthLimit `json:"outbound"`
+}
+
+// Average and peak in KiB/s, burst in KiB, zero averages are no limit
+type VMBandwidthLimit struct {
+	Average int `json:"average"`
+	Peak    int `json:"peak"`
+	Burst   int `json:"burst"`
 }